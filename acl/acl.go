@@ -0,0 +1,281 @@
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/watcher"
+)
+
+// ErrDenied is returned when an address is rejected by an ACL.
+var ErrDenied = errors.New("address denied by access control list")
+
+type Rule struct {
+	Allow   bool
+	Network *net.IPNet
+}
+
+// List is an ordered set of rules. The first rule matching an address
+// decides; addresses matching no rule get the default.
+type List struct {
+	Rules        []Rule
+	DefaultAllow bool
+}
+
+// Permits checks ip and, for IPv6 addresses that carry an IPv4 address
+// (NAT64 and 6to4), the embedded address too. Both must be permitted, so
+// 64:ff9b::7f00:1 cannot be used to reach 127.0.0.1.
+func (l *List) Permits(ip net.IP) bool {
+	if !l.permits(ip) {
+		return false
+	}
+	if embedded := embeddedIPv4(ip); embedded != nil {
+		return l.permits(embedded)
+	}
+	return true
+}
+
+func (l *List) permits(ip net.IP) bool {
+	for _, rule := range l.Rules {
+		if rule.Network.Contains(ip) {
+			return rule.Allow
+		}
+	}
+	return l.DefaultAllow
+}
+
+var (
+	nat64Network      = mustNetwork("64:ff9b::/96")
+	nat64LocalNetwork = mustNetwork("64:ff9b:1::/48")
+	sixToFourNetwork  = mustNetwork("2002::/16")
+)
+
+// embeddedIPv4 returns the IPv4 address translated into a NAT64 or 6to4
+// address, or nil.
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip.To4() != nil || len(ip) != net.IPv6len {
+		return nil
+	}
+	switch {
+	case nat64Network.Contains(ip), nat64LocalNetwork.Contains(ip):
+		return net.IP(ip[12:16])
+	case sixToFourNetwork.Contains(ip):
+		return net.IP(ip[2:6])
+	}
+	return nil
+}
+
+// Parse reads rules of the form
+//
+//	# comment
+//	allow 10.1.0.0/16
+//	deny 10.0.0.0/8
+//	default deny
+//
+// Bare IPs are treated as single-address networks. A file with allow rules
+// must say what happens to everything else, since an allow list that lets
+// unmatched addresses through is almost always a mistake. Without allow
+// rules, unmatched addresses are allowed.
+func Parse(data []byte) (*List, error) {
+	list := &List{DefaultAllow: true}
+	explicitDefault := false
+	hasAllow := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"allow|deny|default <value>\"", lineNumber)
+		}
+
+		switch fields[0] {
+		case "allow", "deny":
+			network, err := ParseNetwork(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			list.Rules = append(list.Rules, Rule{Allow: fields[0] == "allow", Network: network})
+			hasAllow = hasAllow || fields[0] == "allow"
+		case "default":
+			if fields[1] != "allow" && fields[1] != "deny" {
+				return nil, fmt.Errorf("line %d: default must be allow or deny", lineNumber)
+			}
+			list.DefaultAllow = fields[1] == "allow"
+			explicitDefault = true
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", lineNumber, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if hasAllow && !explicitDefault {
+		return nil, fmt.Errorf("allow rules need a \"default allow\" or \"default deny\" line")
+	}
+	return list, nil
+}
+
+// ParseNetwork parses a CIDR or a bare IP address.
+func ParseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func mustNetwork(value string) *net.IPNet {
+	network, err := ParseNetwork(value)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func mustRules(allow bool, values ...string) []Rule {
+	rules := make([]Rule, len(values))
+	for i, value := range values {
+		rules[i] = Rule{Allow: allow, Network: mustNetwork(value)}
+	}
+	return rules
+}
+
+// MetadataRules deny the instance metadata services of the major clouds.
+var MetadataRules = mustRules(false,
+	"169.254.169.254", // AWS, GCP, Azure, OpenStack, DigitalOcean
+	"fd00:ec2::254",   // AWS IPv6
+	"100.100.100.200", // Alibaba Cloud
+	"168.63.129.16",   // Azure WireServer
+)
+
+// PrivateRules deny loopback, private, link-local and other non-public
+// ranges. NAT64 and 6to4 addresses are checked by their embedded IPv4
+// address, see List.Permits.
+var PrivateRules = mustRules(false,
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/96",
+	"2001::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// ACL is a List loaded from a file and swapped atomically when the file
+// changes. Fixed rules are evaluated after the file's rules, so the file can
+// make exceptions to them.
+type ACL struct {
+	name    string
+	path    string
+	fixed   []Rule
+	list    atomic.Pointer[List]
+	watcher *watcher.FileWatcher
+}
+
+// New returns an ACL named name (used in logs) reading rules from path, which
+// may be empty to use only fixed.
+func New(name, path string, fixed []Rule) (*ACL, error) {
+	a := &ACL{name: name, path: path, fixed: fixed}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the rules file. On error the current rules stay in effect.
+func (a *ACL) Reload() error {
+	list := &List{DefaultAllow: true}
+	if a.path != "" {
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s ACL: %v", a.name, err)
+		}
+		if list, err = Parse(data); err != nil {
+			return fmt.Errorf("invalid %s ACL %s: %v", a.name, a.path, err)
+		}
+	}
+
+	rules := len(list.Rules)
+	list.Rules = append(list.Rules, a.fixed...)
+	a.list.Store(list)
+
+	logger.Info("Loaded %s ACL with %d rules (%d built-in)", a.name, rules, len(a.fixed))
+	return nil
+}
+
+func (a *ACL) StartWatching() {
+	if a.path == "" {
+		return
+	}
+	a.watcher = watcher.NewFileWatcher(5*time.Second, a.path)
+	a.watcher.OnChange = func() {
+		if err := a.Reload(); err != nil {
+			logger.Error("Failed to reload %s ACL: %v", a.name, err)
+		}
+	}
+	a.watcher.Start()
+}
+
+func (a *ACL) StopWatching() {
+	if a.watcher != nil {
+		a.watcher.Stop()
+	}
+}
+
+func (a *ACL) Permits(ip net.IP) bool {
+	return a.list.Load().Permits(ip)
+}
+
+// PermitsAddr checks a host or host:port address, which must be a literal IP.
+func (a *ACL) PermitsAddr(address string) bool {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && a.Permits(ip)
+}
+
+// Control can be used as net.Dialer.Control. It runs after DNS resolution,
+// so hostnames that resolve to denied addresses are caught as well.
+func (a *ACL) Control(network, address string, c syscall.RawConn) error {
+	if !a.PermitsAddr(address) {
+		logger.Warning("Blocked connection to %s by %s ACL", address, a.name)
+		return fmt.Errorf("%s: %w", address, ErrDenied)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package acl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantErr     string
+		wantRules   int
+		wantDefault bool
+	}{
+		{"empty", "", "", 0, true},
+		{"comments", "# nothing\n\n", "", 0, true},
+		{"deny list", "deny 10.0.0.0/8\ndeny 192.0.2.1\n", "", 2, true},
+		{"allow list", "allow 10.0.0.0/8\ndefault deny\n", "", 1, false},
+		{"allow exceptions", "allow 10.1.2.3\ndefault allow\n", "", 1, true},
+		{"default before rules", "default deny\nallow ::1\n", "", 1, false},
+		{"allow without default", "allow 10.0.0.0/8\n", "need a \"default allow\" or \"default deny\"", 0, false},
+		{"bad default", "default maybe\n", "default must be allow or deny", 0, false},
+		{"bad action", "permit 10.0.0.0/8\n", "unknown action", 0, false},
+		{"bad network", "deny 10.0.0.0/33\n", "invalid CIDR", 0, false},
+		{"extra field", "deny 10.0.0.0/8 now\n", "expected", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Parse([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(list.Rules) != tt.wantRules || list.DefaultAllow != tt.wantDefault {
+				t.Errorf("Parse() = %d rules, default allow %t; want %d, %t", len(list.Rules), list.DefaultAllow, tt.wantRules, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestListPermits(t *testing.T) {
+	list, err := Parse([]byte("deny 10.1.2.3\nallow 10.0.0.0/8\nallow 2001:db8::/32\ndefault deny\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:10.1.2.3", false},
+		{"192.0.2.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := list.Permits(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Permits(%s) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestBuiltInRules(t *testing.T) {
+	list := &List{DefaultAllow: true}
+	list.Rules = append(append(list.Rules, MetadataRules...), PrivateRules...)
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"169.254.169.254", false},
+		{"fd00:ec2::254", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"::127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"224.0.0.1", false},
+		{"239.255.255.250", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"ff02::1", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		// NAT64 and 6to4 are judged by the IPv4 address they carry.
+		{"64:ff9b::5db8:d70e", true},
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2002:5db8:d70e::1", true},
+		{"2002:7f00:1::1", false},
+		{"2002:a9fe:a9fe::", false},
+	}
+
+	for _, tt := range tests {
+		if got := list.Permits(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Permits(%s) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestACLFileRulesBeforeFixed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "destination.acl")
+	if err := os.WriteFile(path, []byte("allow 10.1.2.3\ndefault allow\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New("destination", path, append(append([]Rule{}, MetadataRules...), PrivateRules...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"10.1.2.3:443", true},
+		{"10.1.2.4:443", false},
+		{"[64:ff9b::a01:203]:443", true},
+		{"93.184.215.14:80", true},
+		{"example.com:80", false},
+	}
+
+	for _, tt := range tests {
+		if got := a.PermitsAddr(tt.address); got != tt.want {
+			t.Errorf("PermitsAddr(%s) = %t, want %t", tt.address, got, tt.want)
+		}
+	}
+}
@@ -4,66 +4,420 @@ import (
 	"flag"
 	"strings"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 	"Groxy/logger"
 )
 
 type AuthModule struct {
-	method AuthMethod
+	router    *PolicyRouter
+	mode      Mode
+	lockout   *Lockout
+	forwarder *IdentityForwarder
+	// identityHeaders are removed from every request before the principal
+	// is forwarded, so clients cannot spoof them.
+	identityHeaders []string
 }
 
+// NewAuthModule applies a single method to every request.
 func NewAuthModule(method AuthMethod) *AuthModule {
+	return NewPolicyAuthModule(&PolicyRouter{
+		Default: &Policy{Name: "default", Method: method},
+	})
+}
+
+// NewPolicyAuthModule selects the policy for each request with router.
+func NewPolicyAuthModule(router *PolicyRouter) *AuthModule {
+	methods := []AuthMethod{}
+	for _, route := range router.Routes {
+		methods = append(methods, route.Policy.Method)
+	}
+	if router.Default != nil {
+		methods = append(methods, router.Default.Method)
+	}
+
+	// Nothing is forwarded until SetForwarder is called, but the default
+	// identity headers are still stripped so clients cannot spoof them.
 	return &AuthModule{
-		method: method,
+		router:          router,
+		mode:            ReverseProxyMode,
+		identityHeaders: append(identityHeaders(methods), NewIdentityForwarder().headers()...),
 	}
 }
 
-func (a *AuthModule) Authenticate(req *http.Request) bool {
-	if a.method == nil {
+// SetMode selects which credentials header is read and how failures are
+// challenged.
+func (a *AuthModule) SetMode(mode Mode) {
+	a.mode = mode
+}
+
+func (a *AuthModule) Mode() Mode {
+	return a.mode
+}
+
+// SetForwarder configures how the principal is passed upstream. A nil
+// forwarder only strips identity headers sent by clients. Identity is only
+// injected in reverse proxy mode; a forward proxy would hand it to whatever
+// site the client visits.
+func (a *AuthModule) SetForwarder(forwarder *IdentityForwarder) {
+	a.forwarder = forwarder
+}
+
+// SetLockout enables brute-force protection. A nil lockout disables it.
+func (a *AuthModule) SetLockout(lockout *Lockout) {
+	a.lockout = lockout
+}
+
+// Lockout returns the failure tracker, which can be used to inspect and clear
+// lockouts at runtime. It is nil when brute-force protection is disabled.
+func (a *AuthModule) Lockout() *Lockout {
+	return a.lockout
+}
+
+// Authenticate returns the principal for req, or nil if it is unauthorized.
+func (a *AuthModule) Authenticate(req *http.Request) *Principal {
+	return a.authenticate(a.router.Select(req), req)
+}
+
+func (a *AuthModule) authenticate(policy *Policy, req *http.Request) *Principal {
+	if policy == nil || policy.Method == nil {
 		logger.Warning("No authentication method configured, allowing request")
-		return true
+		return &Principal{Method: "none"}
+	}
+
+	principal := policy.Method.Authenticate(req, a.mode)
+	if principal == nil {
+		logger.Warning("Request unauthorized by policy %q (%s): %s %s", policy.Name, a.mode, req.Method, req.URL.String())
+	}
+
+	return principal
+}
+
+// Authorize authenticates req and answers with the mode's challenge when it
+// fails. On success it returns req with the principal on its context, the
+// credentials meant for Groxy removed and the identity headers replaced.
+func (a *AuthModule) Authorize(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	policy := a.router.Select(req)
+	ip := clientIP(req)
+	username, _, _ := basicCredentials(req, a.mode)
+
+	if a.lockout != nil {
+		if wait := a.lockout.Check(ip, username); wait > 0 {
+			logger.Warning("Rejected request from locked out client %s (user %q): %s %s", ip, username, req.Method, req.URL.String())
+			tooManyAttempts(w, wait)
+			return req, false
+		}
+	}
+
+	principal := a.authenticate(policy, req)
+	if principal == nil {
+		if a.lockout != nil {
+			if wait := a.lockout.Failure(ip, username); wait > 0 {
+				tooManyAttempts(w, wait)
+				return req, false
+			}
+		}
+		a.challenge(w, policy)
+		return req, false
+	}
+
+	if a.lockout != nil {
+		if policy != nil && requiresCredentials(policy.Method) {
+			a.lockout.Success(ip, username)
+		} else {
+			a.lockout.Release(ip, username)
+		}
+	}
+	a.stripCredentials(req, policy)
+	a.forwardIdentity(req, principal)
+	return req.WithContext(WithPrincipal(req.Context(), principal)), true
+}
+
+func (a *AuthModule) forwardIdentity(req *http.Request, principal *Principal) {
+	for _, header := range a.identityHeaders {
+		req.Header.Del(header)
+	}
+	if a.forwarder != nil {
+		for _, header := range a.forwarder.headers() {
+			req.Header.Del(header)
+		}
+	}
+
+	if a.mode == ForwardProxyMode {
+		return
+	}
+	for header, value := range principal.Headers {
+		req.Header.Set(header, value)
 	}
+	if a.forwarder != nil {
+		a.forwarder.forward(req, principal)
+	}
+}
+
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
 
-	authorized := a.method.Authenticate(req)
-	if !authorized {
-		logger.Warning("Request unauthorized: %s %s", req.Method, req.URL.String())
+func (a *AuthModule) challenge(w http.ResponseWriter, policy *Policy) {
+	if policy != nil && policy.Method != nil {
+		if challenge := policy.Method.Challenge(); challenge != "" {
+			w.Header().Set(a.mode.ChallengeHeader(), challenge)
+		}
 	}
 
-	return authorized
+	status := a.mode.ChallengeStatus()
+	http.Error(w, http.StatusText(status), status)
+}
+
+// stripCredentials removes the header Groxy authenticated with. In forward
+// mode Proxy-Authorization is always hop-by-hop; in reverse mode Authorization
+// is only ours when a method actually consumed it.
+func (a *AuthModule) stripCredentials(req *http.Request, policy *Policy) {
+	if a.mode == ForwardProxyMode {
+		req.Header.Del(a.mode.CredentialsHeader())
+		return
+	}
+
+	if policy != nil && consumesCredentials(policy.Method) {
+		req.Header.Del(a.mode.CredentialsHeader())
+	}
 }
 
 var (
-	authMethod      = flag.String("auth-method", "none", "Authentication method (none, token, basic)")
+	authMethod      = flag.String("auth-method", "none", "Authentication method (none, token, basic, htpasswd, jwt, mtls, hmac, introspection)")
 	authTokens      = flag.String("auth-tokens", "", "Comma-separated list of valid tokens (for token auth)")
 	authUsername    = flag.String("auth-username", "", "Username for basic auth")
 	authPassword    = flag.String("auth-password", "", "Password for basic auth")
+	authJWKS        = flag.String("auth-jwks", "", "JWKS file or URL with the keys JWTs are verified against")
+	authJWTIssuer   = flag.String("auth-jwt-issuer", "", "Required JWT issuer (iss)")
+	authJWTAudience = flag.String("auth-jwt-audience", "", "Required JWT audience (aud)")
+	authJWTSkew     = flag.Duration("auth-jwt-skew", 30*time.Second, "Allowed clock skew for JWT exp/nbf checks")
+	authJWTRequire  = flag.String("auth-jwt-require", "", "Comma-separated required claims (e.g., \"scope=admin,email\")")
+	authJWTForward  = flag.String("auth-jwt-forward", "", "Comma-separated claims to forward as headers (e.g., \"sub=X-Auth-Subject\")")
+	authClientCA    = flag.String("auth-client-ca", "", "CA bundle client certificates are verified against (for mtls auth)")
+	authClientCNs   = flag.String("auth-client-cn", "", "Comma-separated allowed client certificate subject CNs")
+	authClientSANs  = flag.String("auth-client-san", "", "Comma-separated allowed client certificate SANs")
+	authClientSPKI  = flag.String("auth-client-spki", "", "Comma-separated allowed client certificate SPKI SHA-256 fingerprints (hex or base64)")
+	authClientCRL   = flag.String("auth-client-crl", "", "CRL file used to reject revoked client certificates")
+	authHtpasswd    = flag.String("auth-htpasswd", "", "htpasswd file for multi-user basic auth (bcrypt, SHA-256-crypt or APR1 hashes)")
+	authHMACSecrets = flag.String("auth-hmac-secrets", "", "File of \"keyId secret\" lines for HMAC request-signing auth")
+	authHMACSkew    = flag.Duration("auth-hmac-skew", 5*time.Minute, "Allowed clock skew for signed request timestamps")
+	authIntrospect  = flag.String("auth-introspection-url", "", "RFC 7662 token introspection endpoint (for introspection auth)")
+	authIntroID     = flag.String("auth-introspection-client-id", "", "Client ID Groxy authenticates to the introspection endpoint with")
+	authIntroSecret = flag.String("auth-introspection-client-secret", "", "Client secret for the introspection endpoint")
+	authIntroScopes = flag.String("auth-introspection-scopes", "", "Comma-separated scopes every introspected token must have")
+	authIntroCache  = flag.Duration("auth-introspection-cache", 5*time.Minute, "Maximum time an active introspection result is cached")
+	authIntroNeg    = flag.Duration("auth-introspection-negative-cache", 30*time.Second, "Time an inactive introspection result is cached")
+	authIntroSize   = flag.Int("auth-introspection-cache-size", 10000, "Maximum number of cached introspection results")
+	authIntroRate   = flag.Float64("auth-introspection-rate", 50, "Maximum introspection queries per second (0 for no limit)")
+	authPolicy      = flag.String("auth-policy", "", "JSON file with composite auth policies and per-route selection (overrides -auth-method)")
+	lockoutAttempts = flag.Int("auth-lockout-attempts", 5, "Failed authentications per client IP or username before a lockout (0 disables)")
+	lockoutBase     = flag.Duration("auth-lockout-base", time.Second, "First lockout duration, doubled for every further failure")
+	lockoutMax      = flag.Duration("auth-lockout-max", 15*time.Minute, "Maximum lockout duration")
+	lockoutWindow   = flag.Duration("auth-lockout-window", 15*time.Minute, "Time after which failed authentications are forgotten")
+	forwardMode     = flag.String("auth-forward", "none", "How the authenticated identity is passed to reverse proxy upstreams (none, headers, jwt)")
+	forwardUser     = flag.String("auth-forward-user-header", "X-Auth-User", "Header carrying the authenticated user")
+	forwardGroups   = flag.String("auth-forward-groups-header", "X-Auth-Groups", "Header carrying the authenticated user's groups")
+	forwardJWT      = flag.String("auth-forward-jwt-header", "X-Auth-Token", "Header carrying the identity JWT signed by Groxy")
+	forwardSecret   = flag.String("auth-forward-jwt-secret", "", "HS256 secret the identity JWT is signed with (for -auth-forward=jwt)")
+	forwardTTL      = flag.Duration("auth-forward-jwt-ttl", time.Minute, "Lifetime of the identity JWT")
+	lockoutAllow    = flag.String("auth-lockout-allow", "", "Comma-separated client IPs or CIDRs exempt from lockouts")
 )
 
+// ClientCAFile returns the client CA bundle the HTTPS listener must request
+// and verify client certificates against, or "" if none is configured.
+func ClientCAFile() string {
+	return *authClientCA
+}
+
 func InitAuthFromFlags() *AuthModule {
-	switch *authMethod {
+	var module *AuthModule
+	if *authPolicy != "" {
+		router, err := LoadPolicies(*authPolicy, newMethodFactory().build)
+		if err != nil {
+			logger.Error("Failed to load auth policies: %v", err)
+			return nil
+		}
+		module = NewPolicyAuthModule(router)
+	} else {
+		if *authMethod == "none" {
+			fmt.Println("⚠️ WARNING: Running proxy with NO AUTHENTICATION. This is not recommended for production!")
+		}
+
+		method, err := newMethodFactory().build(*authMethod)
+		if err != nil {
+			logger.Error("%v", err)
+			return nil
+		}
+		module = NewAuthModule(method)
+	}
+
+	forwarder := NewIdentityForwarder()
+	forwarder.UserHeader = http.CanonicalHeaderKey(*forwardUser)
+	forwarder.GroupsHeader = http.CanonicalHeaderKey(*forwardGroups)
+	forwarder.TokenHeader = http.CanonicalHeaderKey(*forwardJWT)
+	forwarder.TokenTTL = *forwardTTL
+	switch *forwardMode {
+	case "headers":
+		module.SetForwarder(forwarder)
+	case "jwt":
+		if *forwardSecret == "" {
+			logger.Error("-auth-forward=jwt requires -auth-forward-jwt-secret")
+			return nil
+		}
+		forwarder.TokenSecret = []byte(*forwardSecret)
+		module.SetForwarder(forwarder)
+	case "none":
+		module.SetForwarder(nil)
+		module.identityHeaders = append(module.identityHeaders, forwarder.headers()...)
+	default:
+		logger.Error("Invalid -auth-forward mode: %s", *forwardMode)
+		return nil
+	}
+
+	if *lockoutAttempts > 0 {
+		allowed, err := ParseNetworks(*lockoutAllow)
+		if err != nil {
+			logger.Error("Invalid -auth-lockout-allow: %v", err)
+			return nil
+		}
+		module.SetLockout(NewLockout(LockoutConfig{
+			Threshold: *lockoutAttempts,
+			BaseDelay: *lockoutBase,
+			MaxDelay:  *lockoutMax,
+			Window:    *lockoutWindow,
+			Allowed:   allowed,
+		}))
+	}
+	return module
+}
+
+// methodFactory builds methods from the command-line flags, once per name,
+// so policies sharing a method also share its reloaders and caches.
+type methodFactory struct {
+	methods map[string]AuthMethod
+}
+
+func newMethodFactory() *methodFactory {
+	return &methodFactory{methods: make(map[string]AuthMethod)}
+}
+
+func (f *methodFactory) build(name string) (AuthMethod, error) {
+	if method, ok := f.methods[name]; ok {
+		return method, nil
+	}
+	method, err := methodFromFlags(name)
+	if err != nil {
+		return nil, err
+	}
+	f.methods[name] = method
+	return method, nil
+}
+
+func methodFromFlags(name string) (AuthMethod, error) {
+	switch name {
 	case "token":
 		if *authTokens == "" {
-			logger.Error("No tokens provided for token-based authentication")
-			return nil
+			return nil, fmt.Errorf("no tokens provided for token-based authentication")
 		}
 		tokens := strings.Split(*authTokens, ",")
-		return NewAuthModule(NewTokenAuth(tokens))
+		return NewTokenAuth(tokens), nil
 
 	case "basic":
 		if *authUsername == "" || *authPassword == "" {
-			logger.Error("Username and password are required for basic authentication")
-			return nil
+			return nil, fmt.Errorf("username and password are required for basic authentication")
 		}
-		return NewAuthModule(NewBasicAuth(*authUsername, *authPassword))
+		return NewBasicAuth(*authUsername, *authPassword), nil
+
+	case "htpasswd":
+		if *authHtpasswd == "" {
+			return nil, fmt.Errorf("an htpasswd file is required for htpasswd authentication")
+		}
+		htpasswd, err := NewHtpasswdAuth(*authHtpasswd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load htpasswd file: %v", err)
+		}
+		htpasswd.StartWatching()
+		return htpasswd, nil
+
+	case "jwt":
+		if *authJWKS == "" {
+			return nil, fmt.Errorf("a JWKS file or URL is required for JWT authentication")
+		}
+		keys, err := LoadJWKS(*authJWKS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %v", err)
+		}
+		forward, err := ParseHeaderMapping(*authJWTForward)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -auth-jwt-forward: %v", err)
+		}
+		keys.StartRefreshing(15 * time.Minute)
+
+		jwtAuth := NewJWTAuth(keys)
+		jwtAuth.Issuer = *authJWTIssuer
+		jwtAuth.Audience = *authJWTAudience
+		jwtAuth.ClockSkew = *authJWTSkew
+		jwtAuth.Required = ParseClaimRules(*authJWTRequire)
+		jwtAuth.ForwardClaims = forward
+		return jwtAuth, nil
+
+	case "mtls":
+		if *authClientCA == "" {
+			return nil, fmt.Errorf("a client CA bundle is required for mtls authentication")
+		}
+		clientCert := NewClientCertAuth(*authClientCA)
+		for _, cn := range splitList(*authClientCNs) {
+			clientCert.AllowedCNs[cn] = true
+		}
+		for _, san := range splitList(*authClientSANs) {
+			clientCert.AllowedSANs[san] = true
+		}
+		for _, fingerprint := range splitList(*authClientSPKI) {
+			if err := clientCert.AllowSPKI(fingerprint); err != nil {
+				return nil, err
+			}
+		}
+		if *authClientCRL != "" {
+			if err := clientCert.LoadCRL(*authClientCRL); err != nil {
+				return nil, err
+			}
+		}
+		return clientCert, nil
+
+	case "hmac":
+		if *authHMACSecrets == "" {
+			return nil, fmt.Errorf("a secrets file is required for hmac authentication")
+		}
+		hmacAuth, err := NewHMACAuth(*authHMACSecrets)
+		if err != nil {
+			return nil, err
+		}
+		hmacAuth.MaxSkew = *authHMACSkew
+		hmacAuth.StartWatching()
+		return hmacAuth, nil
+
+	case "introspection":
+		if *authIntrospect == "" {
+			return nil, fmt.Errorf("an introspection endpoint is required for introspection authentication")
+		}
+		introspection := NewIntrospectionAuth(*authIntrospect)
+		introspection.ClientID = *authIntroID
+		introspection.ClientSecret = *authIntroSecret
+		introspection.Scopes = splitList(*authIntroScopes)
+		introspection.MaxCacheTTL = *authIntroCache
+		introspection.NegativeTTL = *authIntroNeg
+		introspection.MaxCacheEntries = *authIntroSize
+		introspection.QueryRate = *authIntroRate
+		return introspection, nil
 
 	case "none":
-		fmt.Println("⚠️ WARNING: Running proxy with NO AUTHENTICATION. This is not recommended for production!")
-		return NewAuthModule(&NoAuth{})
+		return &NoAuth{}, nil
 
 	default:
-		logger.Error("Invalid authentication method: %s", *authMethod)
-		return nil
+		return nil, fmt.Errorf("invalid authentication method: %s", name)
 	}
 }
-
-
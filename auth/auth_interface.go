@@ -1,18 +1,70 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
 	"net/http"
 	"strings"
 )
 
+// Mode tells authentication methods whether Groxy is acting as a forward
+// proxy, where clients send Proxy-Authorization and expect 407 challenges,
+// or as a reverse proxy, where they send Authorization and expect 401.
+type Mode int
+
+const (
+	ReverseProxyMode Mode = iota
+	ForwardProxyMode
+)
+
+func (m Mode) String() string {
+	if m == ForwardProxyMode {
+		return "forward proxy"
+	}
+	return "reverse proxy"
+}
+
+// CredentialsHeader is the header clients put their credentials for Groxy in.
+func (m Mode) CredentialsHeader() string {
+	if m == ForwardProxyMode {
+		return "Proxy-Authorization"
+	}
+	return "Authorization"
+}
+
+func (m Mode) ChallengeHeader() string {
+	if m == ForwardProxyMode {
+		return "Proxy-Authenticate"
+	}
+	return "WWW-Authenticate"
+}
+
+func (m Mode) ChallengeStatus() int {
+	if m == ForwardProxyMode {
+		return http.StatusProxyAuthRequired
+	}
+	return http.StatusUnauthorized
+}
+
+// AuthMethod authenticates a request and, on success, returns the identity
+// it proved. A nil principal means the request was rejected.
 type AuthMethod interface {
-	Authenticate(req *http.Request) bool
+	Authenticate(req *http.Request, mode Mode) *Principal
+	// Challenge returns the authenticate header value sent when a request
+	// is rejected, or "" if the method has none.
+	Challenge() string
 }
 
+const realm = "Groxy"
+
 type NoAuth struct{}
 
-func (n *NoAuth) Authenticate(req *http.Request) bool {
-	return true
+func (n *NoAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	return &Principal{Method: "none"}
+}
+
+func (n *NoAuth) Challenge() string {
+	return ""
 }
 
 // TokenAuth 
@@ -30,16 +82,22 @@ func NewTokenAuth(validTokens []string) *TokenAuth {
 	}
 }
 
-func (t *TokenAuth) Authenticate(req *http.Request) bool {
-	token := req.Header.Get("Authorization")
+func (t *TokenAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	token := req.Header.Get(mode.CredentialsHeader())
 	if token == "" {
-		return false
+		return nil
 	}
 
 	token = strings.TrimPrefix(token, "Bearer ")
 
-	_, valid := t.ValidTokens[token]
-	return valid
+	if _, valid := t.ValidTokens[token]; !valid {
+		return nil
+	}
+	return &Principal{Method: "token"}
+}
+
+func (t *TokenAuth) Challenge() string {
+	return `Bearer realm="` + realm + `"`
 }
 
 // BasicAuth
@@ -55,11 +113,37 @@ func NewBasicAuth(username, password string) *BasicAuth {
 	}
 }
 
-func (b *BasicAuth) Authenticate(req *http.Request) bool {
-	username, password, ok := req.BasicAuth()
+func (b *BasicAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	username, password, ok := basicCredentials(req, mode)
 	if !ok {
-		return false
+		return nil
+	}
+
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(b.Username))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(b.Password))
+	if usernameMatch&passwordMatch != 1 {
+		return nil
 	}
+	return &Principal{User: username, Method: "basic"}
+}
 
-	return username == b.Username && password == b.Password
-}
\ No newline at end of file
+func (b *BasicAuth) Challenge() string {
+	return `Basic realm="` + realm + `", charset="UTF-8"`
+}
+
+// basicCredentials is req.BasicAuth for whichever header mode uses.
+func basicCredentials(req *http.Request, mode Mode) (username, password string, ok bool) {
+	const prefix = "Basic "
+	header := req.Header.Get(mode.CredentialsHeader())
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdentityForwarding(t *testing.T) {
+	credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	tests := []struct {
+		name      string
+		mode      Mode
+		forwarder *IdentityForwarder
+		wantUser  string
+	}{
+		{"reverse proxy without forwarder", ReverseProxyMode, nil, ""},
+		{"reverse proxy with headers", ReverseProxyMode, NewIdentityForwarder(), "alice"},
+		{"forward proxy without forwarder", ForwardProxyMode, nil, ""},
+		{"forward proxy never injects", ForwardProxyMode, NewIdentityForwarder(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := NewAuthModule(NewBasicAuth("alice", "secret"))
+			module.SetMode(tt.mode)
+			if tt.forwarder != nil {
+				module.SetForwarder(tt.forwarder)
+			}
+
+			req := httptest.NewRequest("GET", "http://upstream.example.com/", nil)
+			req.Header.Set(tt.mode.CredentialsHeader(), credentials)
+			req.Header.Set("X-Auth-User", "admin")
+			req.Header.Set("X-Auth-Groups", "admins")
+
+			authorized, ok := module.Authorize(httptest.NewRecorder(), req)
+			if !ok {
+				t.Fatal("request was not authorized")
+			}
+			if got := authorized.Header.Get("X-Auth-User"); got != tt.wantUser {
+				t.Errorf("X-Auth-User = %q, want %q", got, tt.wantUser)
+			}
+			if got := authorized.Header.Get("X-Auth-Groups"); got != "" {
+				t.Errorf("spoofed X-Auth-Groups %q was forwarded", got)
+			}
+		})
+	}
+}
+
+func TestAuthorizeChallenge(t *testing.T) {
+	basicChallenge := `Basic realm="Groxy", charset="UTF-8"`
+
+	tests := []struct {
+		name          string
+		mode          Mode
+		method        AuthMethod
+		header        string
+		credentials   string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{"forward proxy without credentials", ForwardProxyMode, NewBasicAuth("alice", "secret"), "", "", http.StatusProxyAuthRequired, basicChallenge},
+		{"forward proxy with wrong password", ForwardProxyMode, NewBasicAuth("alice", "secret"), "Proxy-Authorization", basicCredentialsFor("alice", "wrong"), http.StatusProxyAuthRequired, basicChallenge},
+		{"forward proxy ignores Authorization", ForwardProxyMode, NewBasicAuth("alice", "secret"), "Authorization", basicCredentialsFor("alice", "secret"), http.StatusProxyAuthRequired, basicChallenge},
+		{"forward proxy token", ForwardProxyMode, NewTokenAuth([]string{"t0ken"}), "", "", http.StatusProxyAuthRequired, `Bearer realm="Groxy"`},
+		{"reverse proxy without credentials", ReverseProxyMode, NewBasicAuth("alice", "secret"), "", "", http.StatusUnauthorized, basicChallenge},
+		{"reverse proxy ignores Proxy-Authorization", ReverseProxyMode, NewBasicAuth("alice", "secret"), "Proxy-Authorization", basicCredentialsFor("alice", "secret"), http.StatusUnauthorized, basicChallenge},
+		{"reverse proxy token", ReverseProxyMode, NewTokenAuth([]string{"t0ken"}), "Authorization", "Bearer wrong", http.StatusUnauthorized, `Bearer realm="Groxy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := NewAuthModule(tt.method)
+			module.SetMode(tt.mode)
+
+			req := httptest.NewRequest("GET", "http://upstream.example.com/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.credentials)
+			}
+			rec := httptest.NewRecorder()
+			if _, ok := module.Authorize(rec, req); ok {
+				t.Fatal("request was authorized")
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get(tt.mode.ChallengeHeader()); got != tt.wantChallenge {
+				t.Errorf("%s = %q, want %q", tt.mode.ChallengeHeader(), got, tt.wantChallenge)
+			}
+			other := ReverseProxyMode
+			if tt.mode == ReverseProxyMode {
+				other = ForwardProxyMode
+			}
+			if got := rec.Header().Get(other.ChallengeHeader()); got != "" {
+				t.Errorf("unexpected %s: %q", other.ChallengeHeader(), got)
+			}
+		})
+	}
+}
+
+func TestAuthorizeStripsCredentials(t *testing.T) {
+	upstreamCredentials := basicCredentialsFor("upstream", "password")
+
+	tests := []struct {
+		name              string
+		mode              Mode
+		method            AuthMethod
+		wantProxyAuth     string
+		wantAuthorization string
+	}{
+		{"forward proxy basic", ForwardProxyMode, NewBasicAuth("alice", "secret"), "", upstreamCredentials},
+		{"forward proxy without auth", ForwardProxyMode, &NoAuth{}, "", upstreamCredentials},
+		{"reverse proxy basic", ReverseProxyMode, NewBasicAuth("alice", "secret"), "", ""},
+		{"reverse proxy without auth", ReverseProxyMode, &NoAuth{}, "", upstreamCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := NewAuthModule(tt.method)
+			module.SetMode(tt.mode)
+
+			req := httptest.NewRequest("GET", "http://upstream.example.com/", nil)
+			if tt.mode == ForwardProxyMode {
+				req.Header.Set("Proxy-Authorization", basicCredentialsFor("alice", "secret"))
+				req.Header.Set("Authorization", upstreamCredentials)
+			} else if _, ok := tt.method.(*NoAuth); ok {
+				req.Header.Set("Authorization", upstreamCredentials)
+			} else {
+				req.Header.Set("Authorization", basicCredentialsFor("alice", "secret"))
+			}
+
+			authorized, ok := module.Authorize(httptest.NewRecorder(), req)
+			if !ok {
+				t.Fatal("request was not authorized")
+			}
+			if got := authorized.Header.Get("Proxy-Authorization"); got != tt.wantProxyAuth {
+				t.Errorf("Proxy-Authorization = %q, want %q", got, tt.wantProxyAuth)
+			}
+			if got := authorized.Header.Get("Authorization"); got != tt.wantAuthorization {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuthorization)
+			}
+		})
+	}
+}
+
+func basicCredentialsFor(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
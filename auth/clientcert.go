@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/tls"
+	"Groxy/watcher"
+)
+
+// Headers carrying the verified client certificate identity upstream. Copies
+// sent by the client are always removed.
+const (
+	ClientCertSubjectHeader     = "X-Client-Cert-Subject"
+	ClientCertSANHeader         = "X-Client-Cert-San"
+	ClientCertFingerprintHeader = "X-Client-Cert-Spki-Sha256"
+)
+
+// ClientCertAuth authenticates clients by the certificate they presented in
+// the TLS handshake. The chain itself is verified against the client CA
+// bundle by the HTTPS listener; this method applies the allow-lists and the
+// optional CRL on top. With no allow-list configured, any certificate issued
+// by the client CA is accepted.
+type ClientCertAuth struct {
+	AllowedCNs  map[string]bool
+	AllowedSANs map[string]bool
+	// AllowedSPKI holds lowercase hex SHA-256 fingerprints of the subject
+	// public key info.
+	AllowedSPKI map[string]bool
+
+	caFile     string
+	crlFile    string
+	revoked    map[revocationKey]bool
+	crlMu      sync.RWMutex
+	crlWatcher *watcher.FileWatcher
+}
+
+// revocationKey identifies a certificate by its issuer's raw subject and its
+// serial number. Serials are only unique per issuer, so a CRL entry must not
+// revoke a certificate another client CA issued with the same serial.
+type revocationKey struct {
+	issuer string
+	serial string
+}
+
+func certificateKey(cert *x509.Certificate) revocationKey {
+	return revocationKey{issuer: string(cert.RawIssuer), serial: cert.SerialNumber.String()}
+}
+
+func NewClientCertAuth(caFile string) *ClientCertAuth {
+	return &ClientCertAuth{
+		AllowedCNs:  make(map[string]bool),
+		AllowedSANs: make(map[string]bool),
+		AllowedSPKI: make(map[string]bool),
+		caFile:      caFile,
+	}
+}
+
+// AllowSPKI adds a fingerprint given as hex (colons allowed) or base64.
+func (c *ClientCertAuth) AllowSPKI(fingerprint string) error {
+	normalized, err := tls.NormalizePin(fingerprint)
+	if err != nil {
+		return err
+	}
+	c.AllowedSPKI[normalized] = true
+	return nil
+}
+
+// LoadCRL enables revocation checks against a PEM or DER CRL file signed by
+// the client CA named as its issuer. Entries only revoke certificates from
+// that CA. The file is reloaded when it changes.
+func (c *ClientCertAuth) LoadCRL(path string) error {
+	c.crlFile = path
+	if err := c.reloadCRL(); err != nil {
+		return err
+	}
+
+	c.crlWatcher = watcher.NewFileWatcher(30*time.Second, path)
+	c.crlWatcher.OnChange = func() {
+		if err := c.reloadCRL(); err != nil {
+			logger.Error("Failed to reload CRL %s: %v", path, err)
+		}
+	}
+	c.crlWatcher.Start()
+	return nil
+}
+
+func (c *ClientCertAuth) reloadCRL() error {
+	data, err := os.ReadFile(c.crlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %v", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse CRL: %v", err)
+	}
+
+	cas, err := readCertificates(c.caFile)
+	if err != nil {
+		return err
+	}
+	if err := checkCRLIssuer(crl, cas); err != nil {
+		return fmt.Errorf("CRL %s: %v", c.crlFile, err)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		logger.Warning("CRL %s is stale (next update was %s)", c.crlFile, crl.NextUpdate.Format(time.RFC3339))
+	}
+
+	revoked := make(map[revocationKey]bool, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[revocationKey{issuer: string(crl.RawIssuer), serial: entry.SerialNumber.String()}] = true
+	}
+
+	c.crlMu.Lock()
+	c.revoked = revoked
+	c.crlMu.Unlock()
+
+	logger.Info("Loaded CRL %s with %d revoked certificates", c.crlFile, len(revoked))
+	return nil
+}
+
+func (c *ClientCertAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	leaf := req.TLS.VerifiedChains[0][0]
+	fingerprint := tls.SPKIFingerprint(leaf)
+
+	c.crlMu.RLock()
+	revoked := c.revoked[certificateKey(leaf)]
+	c.crlMu.RUnlock()
+	if revoked {
+		logger.Warning("Rejected revoked client certificate: %s (serial %s)", leaf.Subject.String(), leaf.SerialNumber)
+		return nil
+	}
+
+	if !c.allowed(leaf, fingerprint) {
+		logger.Warning("Client certificate not in allow-list: %s (SPKI %s)", leaf.Subject.String(), fingerprint)
+		return nil
+	}
+
+	logger.Info("Client certificate authenticated: %s (SPKI %s)", leaf.Subject.String(), fingerprint)
+	principal := &Principal{
+		User:   leaf.Subject.CommonName,
+		Groups: leaf.Subject.OrganizationalUnit,
+		Method: "mtls",
+		Headers: map[string]string{
+			ClientCertSubjectHeader:     leaf.Subject.String(),
+			ClientCertFingerprintHeader: fingerprint,
+		},
+	}
+	if sans := certificateSANs(leaf); len(sans) > 0 {
+		principal.Headers[ClientCertSANHeader] = strings.Join(sans, ",")
+	}
+	return principal
+}
+
+func (c *ClientCertAuth) IdentityHeaders() []string {
+	return []string{ClientCertSubjectHeader, ClientCertSANHeader, ClientCertFingerprintHeader}
+}
+
+func (c *ClientCertAuth) Challenge() string {
+	return ""
+}
+
+func (c *ClientCertAuth) allowed(leaf *x509.Certificate, fingerprint string) bool {
+	if len(c.AllowedCNs) == 0 && len(c.AllowedSANs) == 0 && len(c.AllowedSPKI) == 0 {
+		return true
+	}
+	if c.AllowedCNs[leaf.Subject.CommonName] || c.AllowedSPKI[fingerprint] {
+		return true
+	}
+	for _, san := range certificateSANs(leaf) {
+		if c.AllowedSANs[san] {
+			return true
+		}
+	}
+	return false
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// checkCRLIssuer verifies the CRL signature with the client CA whose subject
+// matches the CRL issuer. A CRL signed by one CA but naming another is
+// rejected.
+func checkCRLIssuer(crl *x509.RevocationList, cas []*x509.Certificate) error {
+	for _, ca := range cas {
+		if !bytes.Equal(ca.RawSubject, crl.RawIssuer) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(ca); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("not signed by its issuer %s among the client CAs", crl.Issuer.String())
+}
+
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %v", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return certs, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (ca *testCA) crl(t *testing.T, serials ...int64) []byte {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func writeCAs(t *testing.T, cas ...*testCA) string {
+	t.Helper()
+	var data []byte
+	for _, ca := range cas {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "client-ca.pem")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestCRL(t *testing.T, c *ClientCertAuth, crl []byte) error {
+	t.Helper()
+	c.crlFile = filepath.Join(t.TempDir(), "client.crl")
+	if err := os.WriteFile(c.crlFile, crl, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return c.reloadCRL()
+}
+
+func authenticateCert(c *ClientCertAuth, leaf, issuer *x509.Certificate) *Principal {
+	req := httptest.NewRequest("GET", "https://proxy.example.com/", nil)
+	req.TLS = &cryptotls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, issuer}}}
+	return c.Authenticate(req, ReverseProxyMode)
+}
+
+func base64SPKI(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestClientCertRevocationIsPerIssuer(t *testing.T) {
+	caA := newTestCA(t, "Client CA A")
+	caB := newTestCA(t, "Client CA B")
+	c := NewClientCertAuth(writeCAs(t, caA, caB))
+	if err := loadTestCRL(t, c, caA.crl(t, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ca      *testCA
+		serial  int64
+		allowed bool
+	}{
+		{"revoked by its issuer", caA, 7, false},
+		{"other serial from the same issuer", caA, 8, true},
+		{"same serial from another issuer", caB, 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := tt.ca.issue(t, "client", tt.serial)
+			if got := authenticateCert(c, leaf, tt.ca.cert) != nil; got != tt.allowed {
+				t.Errorf("allowed = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestClientCertCRLIssuer(t *testing.T) {
+	caA := newTestCA(t, "Client CA A")
+	caB := newTestCA(t, "Client CA B")
+	// Same name as A but a different key, so its CRLs claim to come from A.
+	impostor := newTestCA(t, "Client CA A")
+	outsider := newTestCA(t, "Outside CA")
+
+	tests := []struct {
+		name    string
+		crl     []byte
+		wantErr bool
+	}{
+		{"signed by first CA", caA.crl(t, 1), false},
+		{"signed by second CA", caB.crl(t, 1), false},
+		{"issuer name matches but signature does not", impostor.crl(t, 1), true},
+		{"issuer not a client CA", outsider.crl(t, 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClientCertAuth(writeCAs(t, caA, caB))
+			err := loadTestCRL(t, c, tt.crl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("reloadCRL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientCertAllowSPKI(t *testing.T) {
+	ca := newTestCA(t, "Client CA")
+	leaf := ca.issue(t, "client", 2)
+	other := ca.issue(t, "other", 3)
+
+	c := NewClientCertAuth(writeCAs(t, ca))
+	if err := c.AllowSPKI("sha256/" + base64SPKI(leaf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AllowSPKI("not-a-fingerprint"); err == nil {
+		t.Error("AllowSPKI accepted an invalid fingerprint")
+	}
+
+	if authenticateCert(c, leaf, ca.cert) == nil {
+		t.Error("pinned certificate was rejected")
+	}
+	if authenticateCert(c, other, ca.cert) != nil {
+		t.Error("unpinned certificate was accepted")
+	}
+}
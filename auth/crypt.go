@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash formats found in Apache htpasswd files.
+const (
+	apr1Prefix      = "$apr1$"
+	sha256Prefix    = "$5$"
+	sha256Rounds    = 5000
+	sha256MinRounds = 1000
+	sha256MaxRounds = 999999999
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+)
+
+// dummyHash returns the hash compared against for unknown users so that a
+// miss costs the same as a wrong password. It is generated on first use
+// rather than at start-up.
+func dummyHash() string {
+	dummyHashOnce.Do(func() {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("groxy-dummy-password"), bcrypt.DefaultCost)
+		dummyHashValue = string(hash)
+	})
+	return dummyHashValue
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func supportedHash(hash string) bool {
+	return isBcryptHash(hash) || strings.HasPrefix(hash, sha256Prefix) || strings.HasPrefix(hash, apr1Prefix)
+}
+
+// verifyPassword checks password against an htpasswd hash in constant time.
+func verifyPassword(hash, password string) bool {
+	switch {
+	case isBcryptHash(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, sha256Prefix):
+		computed, err := sha256Crypt(password, hash)
+		return err == nil && subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	case strings.HasPrefix(hash, apr1Prefix):
+		computed, err := apr1Crypt(password, hash)
+		return err == nil && subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	default:
+		return false
+	}
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// sha256Crypt implements SHA-256-crypt ("$5$") as specified by Ulrich
+// Drepper. setting is a full hash or just "$5$[rounds=N$]salt".
+func sha256Crypt(password, setting string) (string, error) {
+	rest := strings.TrimPrefix(setting, sha256Prefix)
+
+	rounds := sha256Rounds
+	customRounds := false
+	if strings.HasPrefix(rest, "rounds=") {
+		value, after, ok := strings.Cut(strings.TrimPrefix(rest, "rounds="), "$")
+		if !ok {
+			return "", fmt.Errorf("malformed sha256-crypt rounds")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("malformed sha256-crypt rounds: %v", err)
+		}
+		rounds = min(max(n, sha256MinRounds), sha256MaxRounds)
+		customRounds = true
+		rest = after
+	}
+
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	pw, s := []byte(password), []byte(salt)
+
+	b := sha256.New()
+	b.Write(pw)
+	b.Write(s)
+	b.Write(pw)
+	digestB := b.Sum(nil)
+
+	a := sha256.New()
+	a.Write(pw)
+	a.Write(s)
+	a.Write(repeatBytes(digestB, len(pw)))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(pw)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := sha256.New()
+	for i := 0; i < len(pw); i++ {
+		dp.Write(pw)
+	}
+	p := repeatBytes(dp.Sum(nil), len(pw))
+
+	ds := sha256.New()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(s)
+	}
+	sp := repeatBytes(ds.Sum(nil), len(s))
+
+	c := digestA
+	for i := 0; i < rounds; i++ {
+		h := sha256.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sp)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(sha256Prefix)
+	if customRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+
+	order := [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	for _, o := range order {
+		encode24(&out, c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	encode24(&out, 0, c[31], c[30], 3)
+
+	return out.String(), nil
+}
+
+// apr1Crypt implements Apache's MD5-crypt variant ("$apr1$").
+func apr1Crypt(password, setting string) (string, error) {
+	rest := strings.TrimPrefix(setting, apr1Prefix)
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	if salt == "" {
+		return "", fmt.Errorf("missing apr1 salt")
+	}
+
+	pw, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(s)
+	alt.Write(pw)
+	final := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(apr1Prefix))
+	ctx.Write(s)
+	for n := len(pw); n > 0; n -= 16 {
+		ctx.Write(final[:min(n, 16)])
+	}
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final = ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+		final = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(apr1Prefix)
+	out.WriteString(salt)
+	out.WriteByte('$')
+	encode24(&out, final[0], final[6], final[12], 4)
+	encode24(&out, final[1], final[7], final[13], 4)
+	encode24(&out, final[2], final[8], final[14], 4)
+	encode24(&out, final[3], final[9], final[15], 4)
+	encode24(&out, final[4], final[10], final[5], 4)
+	encode24(&out, 0, 0, final[11], 2)
+
+	return out.String(), nil
+}
+
+// repeatBytes returns digest repeated up to n bytes.
+func repeatBytes(digest []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(digest) <= n {
+		out = append(out, digest...)
+	}
+	return append(out, digest[:n-len(out)]...)
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
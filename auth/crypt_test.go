@@ -0,0 +1,92 @@
+package auth
+
+import "testing"
+
+// Test vectors from Ulrich Drepper's SHA-crypt specification.
+func TestSHA256Crypt(t *testing.T) {
+	tests := []struct {
+		setting  string
+		password string
+		want     string
+	}{
+		{"$5$saltstring", "Hello world!",
+			"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"$5$rounds=10000$saltstringsaltstring", "Hello world!",
+			"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"$5$rounds=5000$toolongsaltstring", "This is just a test",
+			"$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5"},
+		{"$5$rounds=1400$anotherlongsaltstring", "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+			"$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1"},
+		{"$5$rounds=77777$short", "we have a short salt string but not a short password",
+			"$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/"},
+		{"$5$rounds=123456$asaltof16chars..", "a short string",
+			"$5$rounds=123456$asaltof16chars..$gP3VQ/6X7UUEW3HkBn2w1/Ptq2jxPyzV/cZKmF/wJvD"},
+		{"$5$rounds=10$roundstoolow", "the minimum number is still observed",
+			"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC"},
+	}
+	for _, tt := range tests {
+		got, err := sha256Crypt(tt.password, tt.setting)
+		if err != nil {
+			t.Errorf("sha256Crypt(%q) error = %v", tt.setting, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sha256Crypt(%q) = %q, want %q", tt.setting, got, tt.want)
+		}
+	}
+}
+
+// Expected values are outputs of openssl passwd -apr1.
+func TestAPR1Crypt(t *testing.T) {
+	tests := []struct {
+		setting  string
+		password string
+		want     string
+	}{
+		{"$apr1$rasmusle", "password", "$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1"},
+		{"$apr1$xxxxxxxx$ignored", "Hello world!", "$apr1$xxxxxxxx$3F4sADaTarkm2syJbVvwC."},
+		{"$apr1$a", "", "$apr1$a$lsAcX0kKaMIVmrCtUuk5b0"},
+		{"$apr1$longsaltvalue", "a much longer password that spans more than sixteen bytes", "$apr1$longsalt$H0OCFW3Dg23icEcks5SaT0"},
+	}
+	for _, tt := range tests {
+		got, err := apr1Crypt(tt.password, tt.setting)
+		if err != nil {
+			t.Errorf("apr1Crypt(%q) error = %v", tt.setting, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("apr1Crypt(%q) = %q, want %q", tt.setting, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"sha256-crypt", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", true},
+		{"sha256-crypt wrong password", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "hello world!", false},
+		{"apr1", "$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1", "password", true},
+		{"apr1 wrong password", "$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1", "Password", false},
+		{"bcrypt", "$2a$04$Op.iJnc6/0jEz1ot.9IfgeY.ZFMKloABp4Zm65h6RFJqcpo3Ee4Uq", "secret", true},
+		{"bcrypt wrong password", "$2a$04$Op.iJnc6/0jEz1ot.9IfgeY.ZFMKloABp4Zm65h6RFJqcpo3Ee4Uq", "Secret", false},
+		{"sha256-crypt bad rounds", "$5$rounds=many$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", false},
+		{"sha256-crypt unterminated rounds", "$5$rounds=5000", "Hello world!", false},
+		{"sha256-crypt truncated", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY", "Hello world!", false},
+		{"apr1 without salt", "$apr1$$LZkql2ZXkmUXsDeCqTR8P1", "password", false},
+		{"truncated bcrypt", "$2a$04$Op.iJnc6/0jEz1ot.9Ifge", "secret", false},
+		{"plain text", "secret", "secret", false},
+		{"unsupported md5-crypt", "$1$saltsalt$2vnaRpHa6Jxjz5n83ok8Z0", "password", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPassword(tt.hash, tt.password); got != tt.want {
+				t.Errorf("verifyPassword(%q, %q) = %v, want %v", tt.hash, tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		// at lists request times as offsets from start.
+		at   []time.Duration
+		want []bool
+	}{
+		{"burst then empty", 1, 2, []time.Duration{0, 0, 0}, []bool{true, true, false}},
+		{"refills over time", 1, 1, []time.Duration{0, 500 * time.Millisecond, time.Second}, []bool{true, false, true}},
+		{"refill capped at burst", 10, 2, []time.Duration{0, time.Hour, time.Hour, time.Hour}, []bool{true, true, true, false}},
+		{"no limit", 0, 0, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var limiter rateLimiter
+			for n, offset := range tt.at {
+				if got := limiter.allow(start.Add(offset), tt.rate, tt.burst); got != tt.want[n] {
+					t.Errorf("request %d at %v: allow() = %t, want %t", n, offset, got, tt.want[n])
+				}
+			}
+		})
+	}
+}
+
+func TestFlightGroupSharesResult(t *testing.T) {
+	var group flightGroup[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for n := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[n], _ = group.do("key", func() (int, error) {
+				if calls.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return 42, nil
+			})
+		}()
+	}
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("function ran %d times, want 1", got)
+	}
+	for n, result := range results {
+		if result != 42 {
+			t.Errorf("caller %d got %d", n, result)
+		}
+	}
+
+	// Errors are shared too, and the next call runs again.
+	failure := errors.New("failed")
+	if _, err := group.do("key", func() (int, error) { return 0, failure }); err != failure {
+		t.Errorf("do() error = %v, want %v", err, failure)
+	}
+	if value, _ := group.do("key", func() (int, error) { return 7, nil }); value != 7 {
+		t.Errorf("do() after completion = %d, want 7", value)
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/signing"
+	"Groxy/watcher"
+)
+
+// maxSignedBody bounds how much of a request body is buffered to check its
+// digest.
+const maxSignedBody = 10 << 20
+
+// HMACAuth authenticates machine clients that sign each request with a
+// per-client secret, using the scheme implemented by the signing package.
+// Secrets are read from a file of "keyId secret" lines, reloaded when it
+// changes.
+type HMACAuth struct {
+	path    string
+	secrets map[string][]byte
+	mu      sync.RWMutex
+	watcher *watcher.FileWatcher
+
+	// MaxSkew is how far the signed timestamp may be from the local clock.
+	MaxSkew time.Duration
+	nonces  *nonceCache
+}
+
+func NewHMACAuth(path string) (*HMACAuth, error) {
+	h := &HMACAuth{
+		path:    path,
+		MaxSkew: 5 * time.Minute,
+		nonces:  newNonceCache(),
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *HMACAuth) Reload() error {
+	secrets, err := readHMACSecrets(h.path)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.secrets = secrets
+	h.mu.Unlock()
+
+	logger.Info("Loaded %d HMAC client secrets from %s", len(secrets), h.path)
+	return nil
+}
+
+func (h *HMACAuth) StartWatching() {
+	h.watcher = watcher.NewFileWatcher(htpasswdReloadInterval, h.path)
+	h.watcher.OnChange = func() {
+		if err := h.Reload(); err != nil {
+			logger.Error("Failed to reload %s: %v", h.path, err)
+		}
+	}
+	h.watcher.Start()
+}
+
+func (h *HMACAuth) StopWatching() {
+	if h.watcher != nil {
+		h.watcher.Stop()
+	}
+}
+
+func (h *HMACAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	keyID, err := h.verify(req, mode, time.Now())
+	if err != nil {
+		logger.Debug("HMAC signature rejected: %v", err)
+		return nil
+	}
+	return &Principal{User: keyID, Method: "hmac"}
+}
+
+func (h *HMACAuth) Challenge() string {
+	return signing.Algorithm + ` realm="` + realm + `"`
+}
+
+func (h *HMACAuth) verify(req *http.Request, mode Mode, now time.Time) (string, error) {
+	sig, err := signing.Parse(req.Header.Get(mode.CredentialsHeader()))
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.RLock()
+	secret, ok := h.secrets[sig.KeyID]
+	h.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown key %q", sig.KeyID)
+	}
+
+	signed := make(map[string]bool, len(sig.SignedHeaders))
+	for _, header := range sig.SignedHeaders {
+		signed[header] = true
+	}
+	for _, header := range signing.RequiredHeaders {
+		if !signed[header] {
+			return "", fmt.Errorf("header %s is not signed", header)
+		}
+	}
+
+	unix, err := strconv.ParseInt(req.Header.Get(signing.DateHeader), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s header", signing.DateHeader)
+	}
+	date := time.Unix(unix, 0)
+	if date.Before(now.Add(-h.MaxSkew)) || date.After(now.Add(h.MaxSkew)) {
+		return "", fmt.Errorf("timestamp %s outside allowed skew", date.Format(time.RFC3339))
+	}
+
+	digest, err := signing.BodyDigest(req, maxSignedBody)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(digest), []byte(req.Header.Get(signing.DigestHeader))) {
+		return "", fmt.Errorf("body digest mismatch")
+	}
+
+	expected := signing.Compute(secret, signing.CanonicalRequest(req, sig.SignedHeaders, digest))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig.Signature))) {
+		return "", fmt.Errorf("signature mismatch for key %q", sig.KeyID)
+	}
+
+	// Only a valid signature may consume a nonce, so forged requests cannot
+	// burn nonces of legitimate ones.
+	nonce := req.Header.Get(signing.NonceHeader)
+	if nonce == "" || !h.nonces.add(sig.KeyID+":"+nonce, now, 2*h.MaxSkew) {
+		return "", fmt.Errorf("replayed nonce for key %q", sig.KeyID)
+	}
+	return sig.KeyID, nil
+}
+
+// nonceCache remembers nonces until their signed timestamp can no longer
+// pass the skew check.
+type nonceCache struct {
+	seen      map[string]time.Time
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add records nonce and reports whether it was new.
+func (c *nonceCache) add(nonce string, now time.Time, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPurge) > time.Minute {
+		c.lastPurge = now
+		for key, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, key)
+			}
+		}
+	}
+
+	if expiry, ok := c.seen[nonce]; ok && now.Before(expiry) {
+		return false
+	}
+	c.seen[nonce] = now.Add(ttl)
+	return true
+}
+
+func readHMACSecrets(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HMAC secrets file: %v", err)
+	}
+	defer file.Close()
+
+	secrets := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"keyId secret\"", path, lineNumber)
+		}
+		secrets[fields[0]] = []byte(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read HMAC secrets file: %v", err)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Groxy/signing"
+)
+
+func newTestHMACAuth(t *testing.T) *HMACAuth {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hmac-secrets")
+	if err := os.WriteFile(path, []byte("# clients\njob-1 s3cret\njob-2 other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHMACAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func signedRequest(t *testing.T, keyID, secret, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "http://api.example.com/v1/jobs?run=1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	signer := signing.NewSigner(keyID, []byte(secret))
+	signer.Headers = []string{"content-type"}
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestHMACAuthVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(req *http.Request)
+		keyID   string
+		secret  string
+		wantErr string
+	}{
+		{"valid", nil, "job-1", "s3cret", ""},
+		{"unknown key", nil, "job-9", "s3cret", "unknown key"},
+		{"wrong secret", nil, "job-1", "guess", "signature mismatch"},
+		{"tampered body", func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader(`{"run":"rm -rf"}`))
+		}, "job-1", "s3cret", "body digest mismatch"},
+		{"tampered query", func(req *http.Request) {
+			req.URL.RawQuery = "run=2"
+		}, "job-1", "s3cret", "signature mismatch"},
+		{"tampered signed header", func(req *http.Request) {
+			req.Header.Set("Content-Type", "text/plain")
+		}, "job-1", "s3cret", "signature mismatch"},
+		{"padded signed header", func(req *http.Request) {
+			req.Header.Set("Content-Type", "  application/json ")
+		}, "job-1", "s3cret", ""},
+		{"old timestamp", func(req *http.Request) {
+			req.Header.Set(signing.DateHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		}, "job-1", "s3cret", "outside allowed skew"},
+		{"required header unsigned", func(req *http.Request) {
+			req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "x-groxy-nonce", "content-type", 1))
+		}, "job-1", "s3cret", "x-groxy-nonce is not signed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHMACAuth(t)
+			req := signedRequest(t, tt.keyID, tt.secret, `{"run":"backup"}`)
+			if tt.tamper != nil {
+				tt.tamper(req)
+			}
+			_, err := h.verify(req, ReverseProxyMode, time.Now())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHMACAuthRejectsReplay(t *testing.T) {
+	h := newTestHMACAuth(t)
+	req := signedRequest(t, "job-1", "s3cret", "payload")
+
+	if _, err := h.verify(req, ReverseProxyMode, time.Now()); err != nil {
+		t.Fatalf("first verify() error = %v", err)
+	}
+	if _, err := h.verify(req, ReverseProxyMode, time.Now()); err == nil || !strings.Contains(err.Error(), "replayed nonce") {
+		t.Fatalf("second verify() error = %v, want replayed nonce", err)
+	}
+}
+
+func TestHMACAuthKeepsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"signed body", []byte(`{"run":"backup"}`)},
+		{"oversized body", bytes.Repeat([]byte("x"), maxSignedBody+10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHMACAuth(t)
+			req := signedRequest(t, "job-1", "s3cret", string(tt.body))
+			h.verify(req, ReverseProxyMode, time.Now())
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil || !bytes.Equal(body, tt.body) {
+				t.Errorf("body after verify = %d bytes (%v), want %d", len(body), err, len(tt.body))
+			}
+		})
+	}
+}
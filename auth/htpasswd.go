@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/watcher"
+)
+
+const htpasswdReloadInterval = 5 * time.Second
+
+// HtpasswdAuth is basic authentication against an Apache htpasswd file. The
+// file is reloaded automatically when it changes on disk.
+type HtpasswdAuth struct {
+	path    string
+	users   map[string]string
+	mu      sync.RWMutex
+	watcher *watcher.FileWatcher
+}
+
+func NewHtpasswdAuth(path string) (*HtpasswdAuth, error) {
+	h := &HtpasswdAuth{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload re-reads the file. On error the previously loaded users stay in
+// effect.
+func (h *HtpasswdAuth) Reload() error {
+	users, err := readHtpasswd(h.path)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+
+	logger.Info("Loaded %d users from %s", len(users), h.path)
+	return nil
+}
+
+func (h *HtpasswdAuth) StartWatching() {
+	h.watcher = watcher.NewFileWatcher(htpasswdReloadInterval, h.path)
+	h.watcher.OnChange = func() {
+		if err := h.Reload(); err != nil {
+			logger.Error("Failed to reload %s: %v", h.path, err)
+		}
+	}
+	h.watcher.Start()
+}
+
+func (h *HtpasswdAuth) StopWatching() {
+	if h.watcher != nil {
+		h.watcher.Stop()
+	}
+}
+
+func (h *HtpasswdAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	username, password, ok := basicCredentials(req, mode)
+	if !ok {
+		return nil
+	}
+
+	h.mu.RLock()
+	hash, found := h.users[username]
+	h.mu.RUnlock()
+
+	if !found {
+		verifyPassword(dummyHash(), password)
+		return nil
+	}
+	if !verifyPassword(hash, password) {
+		return nil
+	}
+	return &Principal{User: username, Method: "htpasswd"}
+}
+
+func (h *HtpasswdAuth) Challenge() string {
+	return `Basic realm="` + realm + `", charset="UTF-8"`
+}
+
+func readHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htpasswd file: %v", err)
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, lineNo)
+		}
+		if !supportedHash(hash) {
+			logger.Warning("%s:%d: unsupported hash format for user %s, skipping", path, lineNo, username)
+			continue
+		}
+		users[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %v", err)
+	}
+	return users, nil
+}
+
+// SetHtpasswdUser adds username to the file at path, or replaces their
+// password, hashing it with bcrypt. The file is created if needed.
+func SetHtpasswdUser(path, username, password string) error {
+	if username == "" || strings.ContainsAny(username, ":\r\n") {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	return updateHtpasswd(path, func(lines []string) ([]string, error) {
+		entry := username + ":" + hash
+		for i, line := range lines {
+			if name, _, ok := strings.Cut(line, ":"); ok && name == username {
+				lines[i] = entry
+				return lines, nil
+			}
+		}
+		return append(lines, entry), nil
+	})
+}
+
+func RemoveHtpasswdUser(path, username string) error {
+	return updateHtpasswd(path, func(lines []string) ([]string, error) {
+		kept := lines[:0]
+		found := false
+		for _, line := range lines {
+			if name, _, ok := strings.Cut(line, ":"); ok && name == username {
+				found = true
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if !found {
+			return nil, fmt.Errorf("user %q not found", username)
+		}
+		return kept, nil
+	})
+}
+
+// updateHtpasswd rewrites the file through a temporary file and a rename,
+// so a watching Groxy never reads a half-written file.
+func updateHtpasswd(path string, update func([]string) ([]string, error)) error {
+	var lines []string
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read htpasswd file: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	lines, err = update(lines)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".htpasswd-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write htpasswd file: %v", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set htpasswd permissions: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write htpasswd file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
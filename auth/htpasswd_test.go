@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func htpasswdLogin(h *HtpasswdAuth, username, password string) bool {
+	req := httptest.NewRequest("GET", "http://upstream.example.com/", nil)
+	req.SetBasicAuth(username, password)
+	return h.Authenticate(req, ReverseProxyMode) != nil
+}
+
+func TestHtpasswdAddRemoveReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := SetHtpasswdUser(path, "alice", "first"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("htpasswd mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	h, err := NewHtpasswdAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !htpasswdLogin(h, "alice", "first") {
+		t.Fatal("added user cannot log in")
+	}
+
+	if err := SetHtpasswdUser(path, "bob", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetHtpasswdUser(path, "alice", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if htpasswdLogin(h, "bob", "second") {
+		t.Error("user added to the file logged in before a reload")
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		password string
+		want     bool
+	}{
+		{"alice", "changed", true},
+		{"alice", "first", false},
+		{"bob", "second", true},
+		{"carol", "second", false},
+	}
+	for _, tt := range tests {
+		if got := htpasswdLogin(h, tt.username, tt.password); got != tt.want {
+			t.Errorf("login as %s/%s = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+
+	if err := RemoveHtpasswdUser(path, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveHtpasswdUser(path, "bob"); err == nil {
+		t.Error("removing a missing user succeeded")
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if htpasswdLogin(h, "bob", "second") {
+		t.Error("removed user can still log in")
+	}
+	if !htpasswdLogin(h, "alice", "changed") {
+		t.Error("remaining user cannot log in after a removal")
+	}
+
+	// A broken file leaves the loaded users in place.
+	if err := os.WriteFile(path, []byte("not an entry\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reload(); err == nil {
+		t.Error("Reload() accepted a malformed file")
+	}
+	if !htpasswdLogin(h, "alice", "changed") {
+		t.Error("users were dropped by a failed reload")
+	}
+}
+
+func TestSetHtpasswdUserRejectsInvalidNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	for _, username := range []string{"", "ali:ce", "alice\nbob:$2y$"} {
+		if err := SetHtpasswdUser(path, username, "secret"); err == nil {
+			t.Errorf("SetHtpasswdUser(%q) succeeded", username)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("htpasswd file written for invalid usernames")
+	}
+}
+
+func TestReadHtpasswd(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantUsers []string
+		wantErr   bool
+	}{
+		{"supported hashes", "# users\n\nalice:$2y$05$abcdefghijklmnopqrstuu5QJ2CkS2k8V1n0tpdOXDcd12Rq7XbO\nbob:$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1\ncarol:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5\n", []string{"alice", "bob", "carol"}, false},
+		{"unsupported hashes skipped", "alice:plaintext\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\ncarol:$1$saltsalt$2vnaRpHa6Jxjz5n83ok8Z0\ndave:$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1\n", []string{"dave"}, false},
+		{"missing separator", "alice\n", nil, true},
+		{"missing username", ":$apr1$rasmusle$LZkql2ZXkmUXsDeCqTR8P1\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "htpasswd")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			users, err := readHtpasswd(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readHtpasswd() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if len(users) != len(tt.wantUsers) {
+				t.Errorf("readHtpasswd() loaded %d users, want %d", len(users), len(tt.wantUsers))
+			}
+			for _, username := range tt.wantUsers {
+				if _, ok := users[username]; !ok {
+					t.Errorf("user %s not loaded", username)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+)
+
+// IntrospectionAuth validates opaque OAuth2 bearer tokens against an RFC 7662
+// token introspection endpoint. Results are cached: active tokens until their
+// exp (capped at MaxCacheTTL), inactive ones for NegativeTTL. When the
+// endpoint cannot be reached the request is rejected.
+//
+// Concurrent requests with the same uncached token share one query, and
+// queries are limited to QueryRate per second so a flood of random tokens
+// cannot be relayed to the endpoint; tokens over the limit are rejected.
+type IntrospectionAuth struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	// Scopes must all be granted to the token.
+	Scopes      []string
+	MaxCacheTTL time.Duration
+	NegativeTTL time.Duration
+	// MaxCacheEntries bounds the cache; the least recently used result is
+	// evicted first.
+	MaxCacheEntries int
+	// QueryRate is the sustained number of introspection queries per second,
+	// with bursts of twice as many. Zero disables the limit.
+	QueryRate float64
+	Client    *http.Client
+
+	cache   *introspectionCache
+	flights *flightGroup[introspectionResult]
+	limiter *rateLimiter
+}
+
+type introspectionResult struct {
+	active  bool
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// introspectionCache is an LRU of results keyed by token hash. order holds
+// the entries, most recently used first.
+type introspectionCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+	mu      sync.Mutex
+}
+
+type introspectionEntry struct {
+	key    string
+	result introspectionResult
+}
+
+func NewIntrospectionAuth(endpoint string) *IntrospectionAuth {
+	return &IntrospectionAuth{
+		Endpoint:        endpoint,
+		MaxCacheTTL:     5 * time.Minute,
+		NegativeTTL:     30 * time.Second,
+		MaxCacheEntries: 10000,
+		QueryRate:       50,
+		Client:          &http.Client{Timeout: 5 * time.Second},
+		cache:           &introspectionCache{entries: make(map[string]*list.Element), order: list.New()},
+		flights:         &flightGroup[introspectionResult]{},
+		limiter:         &rateLimiter{},
+	}
+}
+
+// WithScopes returns a copy requiring scopes instead of i.Scopes. The copy
+// shares the cache and the query limit, so routes with different scopes
+// introspect a token once.
+func (i *IntrospectionAuth) WithScopes(scopes []string) AuthMethod {
+	scoped := *i
+	scoped.Scopes = scopes
+	return &scoped
+}
+
+func (i *IntrospectionAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	token := req.Header.Get(mode.CredentialsHeader())
+	if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+		return nil
+	}
+
+	result, err := i.introspect(token[7:], time.Now())
+	if err != nil {
+		logger.Error("Token introspection failed, rejecting request: %v", err)
+		return nil
+	}
+	if !result.active {
+		logger.Debug("Introspected token is not active")
+		return nil
+	}
+
+	for _, scope := range i.Scopes {
+		if !claimContains("scope", result.claims["scope"], scope) {
+			logger.Warning("Introspected token lacks required scope %q", scope)
+			return nil
+		}
+	}
+
+	user := claimString(result.claims["username"])
+	if user == "" {
+		user = claimString(result.claims["sub"])
+	}
+	return &Principal{User: user, Method: "introspection", Claims: result.claims}
+}
+
+func (i *IntrospectionAuth) Challenge() string {
+	if len(i.Scopes) > 0 {
+		return `Bearer realm="` + realm + `", scope="` + strings.Join(i.Scopes, " ") + `"`
+	}
+	return `Bearer realm="` + realm + `"`
+}
+
+func (i *IntrospectionAuth) introspect(token string, now time.Time) (introspectionResult, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if result, ok := i.cache.get(key, now); ok {
+		return result, nil
+	}
+
+	return i.flights.do(key, func() (introspectionResult, error) {
+		// Another caller may have finished the same query meanwhile.
+		if result, ok := i.cache.get(key, now); ok {
+			return result, nil
+		}
+		if !i.limiter.allow(time.Now(), i.QueryRate, max(1, int(2*i.QueryRate))) {
+			return introspectionResult{}, fmt.Errorf("introspection query rate of %g/s exceeded", i.QueryRate)
+		}
+		return i.fetch(key, token, now)
+	})
+}
+
+func (i *IntrospectionAuth) fetch(key, token string, now time.Time) (introspectionResult, error) {
+	claims, err := i.query(token)
+	if err != nil {
+		return introspectionResult{}, err
+	}
+
+	result := introspectionResult{claims: claims}
+	result.active, _ = claims["active"].(bool)
+	if result.active {
+		result.expires = now.Add(i.MaxCacheTTL)
+		if exp, ok := numericClaim(claims, "exp"); ok {
+			if !exp.After(now) {
+				result.active = false
+			} else if exp.Before(result.expires) {
+				result.expires = exp
+			}
+		}
+	}
+	if !result.active {
+		result.expires = now.Add(i.NegativeTTL)
+	}
+
+	i.cache.put(key, result, i.MaxCacheEntries)
+	return result, nil
+}
+
+func (i *IntrospectionAuth) query(token string) (map[string]interface{}, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, i.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.ClientID), url.QueryEscape(i.ClientSecret))
+	}
+
+	res, err := i.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection endpoint %s unreachable: %v", i.Endpoint, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint %s returned %s", i.Endpoint, res.Status)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %v", err)
+	}
+	if _, ok := claims["active"].(bool); !ok {
+		return nil, fmt.Errorf("introspection response has no active field")
+	}
+	return claims, nil
+}
+
+func (c *introspectionCache) get(key string, now time.Time) (introspectionResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return introspectionResult{}, false
+	}
+	entry := element.Value.(*introspectionEntry)
+	if !now.Before(entry.result.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return introspectionResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.result, true
+}
+
+// put stores result, evicting the least recently used entries beyond limit.
+func (c *introspectionCache) put(key string, result introspectionResult, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*introspectionEntry).result = result
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&introspectionEntry{key: key, result: result})
+	}
+	for limit > 0 && c.order.Len() > limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*introspectionEntry).key)
+	}
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// introspectionServer answers with the claims registered for each token and
+// counts the queries it receives.
+type introspectionServer struct {
+	*httptest.Server
+	tokens  map[string]map[string]interface{}
+	queries atomic.Int32
+	// release, when set, holds every query until it is closed.
+	release chan struct{}
+}
+
+func newIntrospectionServer(t *testing.T, tokens map[string]map[string]interface{}) *introspectionServer {
+	t.Helper()
+	s := &introspectionServer{tokens: tokens}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.queries.Add(1)
+		if s.release != nil {
+			<-s.release
+		}
+		if user, pass, _ := r.BasicAuth(); user != "groxy" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		claims, ok := s.tokens[r.PostFormValue("token")]
+		if !ok {
+			claims = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(claims)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestIntrospection(s *introspectionServer) *IntrospectionAuth {
+	i := NewIntrospectionAuth(s.URL)
+	i.ClientID = "groxy"
+	i.ClientSecret = "secret"
+	return i
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestIntrospectionAuthenticate(t *testing.T) {
+	future := float64(time.Now().Add(time.Hour).Unix())
+	past := float64(time.Now().Add(-time.Hour).Unix())
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice":   {"active": true, "username": "alice", "scope": "read write", "exp": future},
+		"service": {"active": true, "sub": "svc-1", "scope": "read"},
+		"expired": {"active": true, "username": "old", "exp": past},
+		"revoked": {"active": false, "username": "mallory"},
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		scopes   []string
+		wantUser string
+	}{
+		{"active", "alice", nil, "alice"},
+		{"sub fallback", "service", nil, "svc-1"},
+		{"has scopes", "alice", []string{"read", "write"}, "alice"},
+		{"missing scope", "service", []string{"write"}, ""},
+		{"scope prefix is not a scope", "service", []string{"rea"}, ""},
+		{"expired", "expired", nil, ""},
+		{"inactive", "revoked", nil, ""},
+		{"unknown", "nobody", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestIntrospection(server)
+			i.Scopes = tt.scopes
+			principal := i.Authenticate(bearerRequest(tt.token), ReverseProxyMode)
+			if tt.wantUser == "" {
+				if principal != nil {
+					t.Errorf("Authenticate() = %s, want rejection", principal.User)
+				}
+				return
+			}
+			if principal == nil || principal.User != tt.wantUser {
+				t.Errorf("Authenticate() = %v, want %s", principal, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestIntrospectionRejectsOnEndpointError(t *testing.T) {
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{"alice": {"active": true}})
+	i := NewIntrospectionAuth(server.URL)
+	i.ClientID = "groxy"
+	i.ClientSecret = "wrong"
+
+	if principal := i.Authenticate(bearerRequest("alice"), ReverseProxyMode); principal != nil {
+		t.Errorf("Authenticate() = %v despite endpoint error", principal)
+	}
+}
+
+func TestIntrospectionCachesResults(t *testing.T) {
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice": {"active": true, "username": "alice"},
+	})
+	i := newTestIntrospection(server)
+
+	for n := 0; n < 3; n++ {
+		i.Authenticate(bearerRequest("alice"), ReverseProxyMode)
+		i.Authenticate(bearerRequest("unknown"), ReverseProxyMode)
+	}
+	if got := server.queries.Load(); got != 2 {
+		t.Errorf("endpoint queried %d times, want 2", got)
+	}
+
+	// WithScopes copies share the cache.
+	i.WithScopes([]string{"read"}).Authenticate(bearerRequest("alice"), ReverseProxyMode)
+	if got := server.queries.Load(); got != 2 {
+		t.Errorf("scoped copy queried the endpoint again (%d queries)", got)
+	}
+}
+
+func TestIntrospectionCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"a": {"active": true}, "b": {"active": true}, "c": {"active": true},
+	})
+	i := newTestIntrospection(server)
+	i.MaxCacheEntries = 2
+
+	for _, token := range []string{"a", "b", "a", "c"} {
+		i.Authenticate(bearerRequest(token), ReverseProxyMode)
+	}
+	if got := i.cache.order.Len(); got != 2 {
+		t.Fatalf("cache holds %d entries, want 2", got)
+	}
+
+	before := server.queries.Load()
+	i.Authenticate(bearerRequest("a"), ReverseProxyMode)
+	if server.queries.Load() != before {
+		t.Errorf("recently used token a was evicted")
+	}
+	i.Authenticate(bearerRequest("b"), ReverseProxyMode)
+	if server.queries.Load() != before+1 {
+		t.Errorf("least recently used token b was not evicted")
+	}
+}
+
+func TestIntrospectionCoalescesConcurrentQueries(t *testing.T) {
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice": {"active": true, "username": "alice"},
+	})
+	server.release = make(chan struct{})
+	i := newTestIntrospection(server)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for n := 0; n < callers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i.Authenticate(bearerRequest("alice"), ReverseProxyMode) != nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+
+	// Let the callers pile up behind the first query before answering it.
+	for server.queries.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(server.release)
+	wg.Wait()
+
+	if got := server.queries.Load(); got != 1 {
+		t.Errorf("endpoint queried %d times, want 1", got)
+	}
+	if got := accepted.Load(); got != callers {
+		t.Errorf("%d of %d callers accepted", got, callers)
+	}
+}
+
+func TestIntrospectionLimitsQueryRate(t *testing.T) {
+	server := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice": {"active": true, "username": "alice"},
+	})
+	i := newTestIntrospection(server)
+	i.QueryRate = 1
+
+	// The burst of two is spent on made-up tokens.
+	for _, token := range []string{"guess-1", "guess-2", "guess-3", "guess-4"} {
+		i.Authenticate(bearerRequest(token), ReverseProxyMode)
+	}
+	if got := server.queries.Load(); got != 2 {
+		t.Errorf("endpoint queried %d times, want 2", got)
+	}
+	if principal := i.Authenticate(bearerRequest("alice"), ReverseProxyMode); principal != nil {
+		t.Errorf("query over the rate limit was sent")
+	}
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/watcher"
+)
+
+// JWKS is a set of public keys loaded from a local JSON Web Key Set file or
+// fetched from a URL.
+type JWKS struct {
+	source      string
+	keys        map[string]crypto.PublicKey
+	mu          sync.RWMutex
+	lastRefresh time.Time
+	watcher     *watcher.FileWatcher
+	stop        chan struct{}
+
+	// lastAttempt is when an unknown key ID last triggered a refetch,
+	// successful or not. Concurrent refetches are coalesced in refreshes.
+	lastAttempt time.Time
+	refreshes   flightGroup[struct{}]
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// minJWKSRefresh limits how often unknown key IDs trigger a refetch, so
+// tokens with made-up key IDs cannot turn into a flood of JWKS requests.
+const minJWKSRefresh = time.Minute
+
+func LoadJWKS(source string) (*JWKS, error) {
+	k := &JWKS{source: source}
+	if err := k.Refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *JWKS) isRemote() bool {
+	return strings.HasPrefix(k.source, "http://") || strings.HasPrefix(k.source, "https://")
+}
+
+func (k *JWKS) Refresh() error {
+	data, err := k.read()
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS from %s: %v", k.source, err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warning("Skipping JWKS key %d (%s): %v", i, jwk.Kid, err)
+			continue
+		}
+		kid := jwk.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS from %s contains no usable signing keys", k.source)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.lastRefresh = time.Now()
+	k.mu.Unlock()
+
+	logger.Info("Loaded %d JWKS keys from %s", len(keys), k.source)
+	return nil
+}
+
+func (k *JWKS) read() ([]byte, error) {
+	if !k.isRemote() {
+		return os.ReadFile(k.source)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(k.source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// StartRefreshing reloads a JWKS file when it changes, or refetches a JWKS
+// URL every interval.
+func (k *JWKS) StartRefreshing(interval time.Duration) {
+	reload := func() {
+		if err := k.Refresh(); err != nil {
+			logger.Error("JWKS refresh failed: %v", err)
+		}
+	}
+
+	if !k.isRemote() {
+		k.watcher = watcher.NewFileWatcher(5*time.Second, k.source)
+		k.watcher.OnChange = reload
+		k.watcher.Start()
+		return
+	}
+
+	k.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reload()
+			case <-k.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (k *JWKS) StopRefreshing() {
+	if k.watcher != nil {
+		k.watcher.Stop()
+	}
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+// candidates returns the keys a token with kid may be signed with. An
+// unknown kid on a remote set triggers a rate-limited refetch, which picks up
+// keys rotated in by the issuer.
+func (k *JWKS) candidates(kid string) []crypto.PublicKey {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+
+	if kid != "" && !ok && k.isRemote() {
+		k.refreshUnknownKid()
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if ok {
+		return []crypto.PublicKey{key}
+	}
+	if kid != "" {
+		return nil
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]crypto.PublicKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// refreshUnknownKid refetches the set unless it was fetched, or a refetch
+// was attempted, within minJWKSRefresh. Callers arriving while a refetch is
+// running wait for it instead of starting their own.
+func (k *JWKS) refreshUnknownKid() {
+	k.refreshes.do("", func() (struct{}, error) {
+		now := time.Now()
+		k.mu.Lock()
+		recent := now.Sub(k.lastRefresh) < minJWKSRefresh || now.Sub(k.lastAttempt) < minJWKSRefresh
+		if !recent {
+			k.lastAttempt = now
+		}
+		k.mu.Unlock()
+
+		if !recent {
+			if err := k.Refresh(); err != nil {
+				logger.Error("JWKS refresh failed: %v", err)
+			}
+		}
+		return struct{}{}, nil
+	})
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %v", err)
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key too small (%d bits)", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %v", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %v", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"Groxy/logger"
+)
+
+// ClaimRule requires a claim to be present and, when Value is set, to equal
+// it. For array claims it is enough for Value to be one of the entries, and
+// for the space-separated "scope" and "scp" claims one of the scopes.
+type ClaimRule struct {
+	Claim string
+	Value string
+}
+
+// JWTAuth validates bearer JWTs signed with RS256, ES256 or EdDSA against a
+// JWKS.
+type JWTAuth struct {
+	Keys      *JWKS
+	Issuer    string
+	Audience  string
+	ClockSkew time.Duration
+	Required  []ClaimRule
+	// ForwardClaims maps claim names to the request headers their verified
+	// values are forwarded upstream in.
+	ForwardClaims map[string]string
+}
+
+func NewJWTAuth(keys *JWKS) *JWTAuth {
+	return &JWTAuth{
+		Keys:      keys,
+		ClockSkew: 30 * time.Second,
+	}
+}
+
+func (j *JWTAuth) Authenticate(req *http.Request, mode Mode) *Principal {
+	token := req.Header.Get(mode.CredentialsHeader())
+	if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+		return nil
+	}
+
+	claims, err := j.Verify(token[7:])
+	if err != nil {
+		logger.Debug("JWT rejected: %v", err)
+		return nil
+	}
+
+	principal := &Principal{
+		User:    claimString(claims["sub"]),
+		Groups:  claimList(claims["groups"]),
+		Method:  "jwt",
+		Claims:  claims,
+		Headers: make(map[string]string),
+	}
+	for claim, header := range j.ForwardClaims {
+		if value, ok := claims[claim]; ok {
+			principal.Headers[header] = claimString(value)
+		}
+	}
+	return principal
+}
+
+// IdentityHeaders lists the headers ForwardClaims fills, which clients must
+// not be able to set themselves.
+func (j *JWTAuth) IdentityHeaders() []string {
+	headers := make([]string, 0, len(j.ForwardClaims))
+	for _, header := range j.ForwardClaims {
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+func (j *JWTAuth) Challenge() string {
+	return `Bearer realm="` + realm + `"`
+}
+
+// Verify checks the token's signature and claims and returns the claims.
+func (j *JWTAuth) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range j.Keys.candidates(header.Kid) {
+		if verifySignature(header.Alg, key, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("signature verification failed (alg %q, kid %q)", header.Alg, header.Kid)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if err := j.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWTAuth) validateClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if now.After(exp.Add(j.ClockSkew)) {
+		return fmt.Errorf("token expired at %s", exp.Format(time.RFC3339))
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(j.ClockSkew).Before(nbf) {
+		return fmt.Errorf("token not valid before %s", nbf.Format(time.RFC3339))
+	}
+
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if j.Audience != "" && !claimContains("aud", claims["aud"], j.Audience) {
+		return fmt.Errorf("token not issued for audience %s", j.Audience)
+	}
+
+	for _, rule := range j.Required {
+		value, ok := claims[rule.Claim]
+		if !ok {
+			return fmt.Errorf("required claim %s missing", rule.Claim)
+		}
+		if rule.Value != "" && !claimContains(rule.Claim, value, rule.Value) {
+			return fmt.Errorf("claim %s does not match %q", rule.Claim, rule.Value)
+		}
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, input, signature []byte) bool {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, input, signature)
+
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// claimContains reports whether the claim named name equals want or, for
+// arrays, has want as an entry. Only scope claims are space-separated lists
+// (RFC 8693); any other string must match exactly, so an audience of
+// "api other" is not "api".
+func claimContains(name string, value interface{}, want string) bool {
+	switch v := value.(type) {
+	case string:
+		if v == want {
+			return true
+		}
+		if name != "scope" && name != "scp" {
+			return false
+		}
+		for _, field := range strings.Fields(v) {
+			if field == want {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if claimString(item) == want {
+				return true
+			}
+		}
+	case nil:
+		return false
+	default:
+		return claimString(v) == want
+	}
+	return false
+}
+
+func claimString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = claimString(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// claimList reads an array claim, or a space-separated string claim, as a
+// list of strings.
+func claimList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, claimString(item))
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+// ParseClaimRules parses "claim=value,claim" into claim rules.
+func ParseClaimRules(value string) []ClaimRule {
+	var rules []ClaimRule
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		claim, want, _ := strings.Cut(field, "=")
+		rules = append(rules, ClaimRule{Claim: strings.TrimSpace(claim), Value: strings.TrimSpace(want)})
+	}
+	return rules
+}
+
+// ParseHeaderMapping parses "claim=Header,claim=Header" into a map.
+func ParseHeaderMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		claim, header, ok := strings.Cut(field, "=")
+		if !ok || strings.TrimSpace(claim) == "" || strings.TrimSpace(header) == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected claim=Header", field)
+		}
+		mapping[strings.TrimSpace(claim)] = http.CanonicalHeaderKey(strings.TrimSpace(header))
+	}
+	return mapping, nil
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClaimContains(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		value interface{}
+		want  string
+		ok    bool
+	}{
+		{"equal string", "aud", "api", "api", true},
+		{"different string", "aud", "api2", "api", false},
+		{"space-separated aud is one value", "aud", "api other", "api", false},
+		{"array aud", "aud", []interface{}{"other", "api"}, "api", true},
+		{"array aud without it", "aud", []interface{}{"other", "api2"}, "api", false},
+		{"scope list", "scope", "read write", "write", true},
+		{"scp list", "scp", "read write", "read", true},
+		{"scope prefix", "scope", "read write", "rea", false},
+		{"scope array", "scp", []interface{}{"read", "write"}, "write", true},
+		{"custom claim is exact", "role", "admin superuser", "admin", false},
+		{"custom claim equal", "role", "admin", "admin", true},
+		{"number", "level", float64(3), "3", true},
+		{"bool", "email_verified", true, "true", true},
+		{"missing", "aud", nil, "api", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimContains(tt.claim, tt.value, tt.want); got != tt.ok {
+				t.Errorf("claimContains(%q, %v, %q) = %t, want %t", tt.claim, tt.value, tt.want, got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	unix := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+
+	j := &JWTAuth{
+		Issuer:    "https://issuer.example.com",
+		Audience:  "api",
+		ClockSkew: 30 * time.Second,
+		Required:  []ClaimRule{{Claim: "scope", Value: "proxy"}, {Claim: "email"}},
+	}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   "https://issuer.example.com",
+			"aud":   "api",
+			"exp":   unix(time.Hour),
+			"scope": "read proxy",
+			"email": "alice@example.com",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(claims map[string]interface{})
+		wantErr bool
+	}{
+		{"valid", func(map[string]interface{}) {}, false},
+		{"audience array", func(c map[string]interface{}) { c["aud"] = []interface{}{"web", "api"} }, false},
+		{"audience smuggled in a string", func(c map[string]interface{}) { c["aud"] = "web api" }, true},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "web" }, true},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, true},
+		{"no exp", func(c map[string]interface{}) { delete(c, "exp") }, true},
+		{"expired", func(c map[string]interface{}) { c["exp"] = unix(-time.Minute) }, true},
+		{"expired within skew", func(c map[string]interface{}) { c["exp"] = unix(-10 * time.Second) }, false},
+		{"not yet valid", func(c map[string]interface{}) { c["nbf"] = unix(time.Minute) }, true},
+		{"nbf within skew", func(c map[string]interface{}) { c["nbf"] = unix(10 * time.Second) }, false},
+		{"missing scope", func(c map[string]interface{}) { c["scope"] = "read" }, true},
+		{"missing required claim", func(c map[string]interface{}) { delete(c, "email") }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+			if err := j.validateClaims(claims, now); (err != nil) != tt.wantErr {
+				t.Errorf("validateClaims() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type testKey struct {
+	kid     string
+	private ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, private: private}
+}
+
+func (k testKey) jwk() map[string]string {
+	return map[string]string{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"kid": k.kid,
+		"x":   base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+	}
+}
+
+func (k testKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": k.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(k.private, []byte(input)))
+}
+
+// unsigned builds an "alg": "none" token for the current key.
+func unsigned(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "current"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// jwksServer serves the keys it holds and counts fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []testKey
+	fetches atomic.Int32
+	delay   time.Duration
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		time.Sleep(s.delay)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		set := map[string][]map[string]string{"keys": {}}
+		for _, key := range s.keys {
+			set["keys"] = append(set["keys"], key.jwk())
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) rotate(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// expireRefresh makes the set look old enough for an unknown kid to trigger
+// a refetch.
+func expireRefresh(k *JWKS) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastRefresh = time.Now().Add(-2 * minJWKSRefresh)
+	k.lastAttempt = time.Time{}
+}
+
+func TestJWTVerify(t *testing.T) {
+	current := newTestKey(t, "current")
+	other := newTestKey(t, "other")
+	server := newJWKSServer(t, current)
+	keys, err := LoadJWKS(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJWTAuth(keys)
+	j.Audience = "api"
+
+	claims := map[string]interface{}{"sub": "alice", "aud": "api", "exp": float64(time.Now().Add(time.Hour).Unix())}
+	forged := testKey{kid: "current", private: other.private}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"signed by current key", current.sign(t, claims), false},
+		{"signed by unknown key", other.sign(t, claims), true},
+		{"wrong key for kid", forged.sign(t, claims), true},
+		{"malformed", "not.a-token", true},
+		{"alg none", unsigned(t, claims), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := j.Verify(tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSRefreshOnRotatedKey(t *testing.T) {
+	old := newTestKey(t, "old")
+	rotated := newTestKey(t, "rotated")
+	server := newJWKSServer(t, old)
+	keys, err := LoadJWKS(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJWTAuth(keys)
+	token := rotated.sign(t, map[string]interface{}{"sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())})
+
+	server.rotate(old, rotated)
+	if _, err := j.Verify(token); err == nil {
+		t.Fatal("unknown kid accepted without a refetch")
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Fatalf("refetched %d times right after loading, want no refetch", got-1)
+	}
+
+	expireRefresh(keys)
+	if _, err := j.Verify(token); err != nil {
+		t.Fatalf("Verify() after rotation error = %v", err)
+	}
+	if got := server.fetches.Load(); got != 2 {
+		t.Errorf("fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSRefreshIsCoalescedAndRateLimited(t *testing.T) {
+	server := newJWKSServer(t, newTestKey(t, "current"))
+	server.delay = 50 * time.Millisecond
+	keys, err := LoadJWKS(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireRefresh(keys)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys.candidates("made-up")
+		}()
+	}
+	wg.Wait()
+	if got := server.fetches.Load(); got != 2 {
+		t.Errorf("concurrent unknown kids fetched %d times, want 1 refetch", got-1)
+	}
+
+	// A failed refetch still counts, so a broken endpoint is not hammered.
+	server.Close()
+	expireRefresh(keys)
+	keys.candidates("made-up")
+	keys.mu.RLock()
+	attempted := keys.lastAttempt
+	keys.mu.RUnlock()
+	for n := 0; n < 5; n++ {
+		keys.candidates("made-up")
+	}
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	if attempted.IsZero() || !keys.lastAttempt.Equal(attempted) {
+		t.Errorf("unknown kids retried a failed refetch within %v", minJWKSRefresh)
+	}
+}
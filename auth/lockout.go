@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+)
+
+// LockoutConfig controls brute-force protection. After Threshold failures a
+// client IP or username is locked for BaseDelay, doubling with every further
+// failure up to MaxDelay. Failures older than Window are forgotten.
+type LockoutConfig struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+	// Allowed clients are never tracked or locked.
+	Allowed []*net.IPNet
+}
+
+// LockoutStatus describes one tracked client IP or username.
+type LockoutStatus struct {
+	Key      string
+	Failures int
+	// Pending counts attempts admitted by Check whose outcome is not known
+	// yet.
+	Pending     int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+func (s LockoutStatus) Locked(now time.Time) bool {
+	return now.Before(s.LockedUntil)
+}
+
+// Lockout tracks authentication failures per client IP and per username.
+// Keys are "ip:<address>" and "user:<name>".
+type Lockout struct {
+	config    LockoutConfig
+	entries   map[string]*LockoutStatus
+	mu        sync.Mutex
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewLockout(config LockoutConfig) *Lockout {
+	return &Lockout{
+		config:  config,
+		entries: make(map[string]*LockoutStatus),
+		now:     time.Now,
+	}
+}
+
+func (l *Lockout) allowed(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range l.config.Allowed {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns how long the request's client or username stays locked, or
+// zero if it may try to authenticate. An admitted attempt is reserved as if
+// it had failed, so concurrent attempts cannot all pass before the first
+// failure is recorded; the caller must settle it with Failure, Success or
+// Release. Once a lock runs out, a single attempt is admitted at a time, and
+// its failure locks again for twice as long.
+func (l *Lockout) Check(ip, username string) time.Duration {
+	if l.allowed(ip) {
+		return 0
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.purge(now)
+
+	keys := lockoutKeys(ip, username)
+	var wait time.Duration
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			continue
+		}
+		l.expire(entry, now)
+		switch {
+		case entry.Locked(now):
+			wait = max(wait, entry.LockedUntil.Sub(now))
+		case entry.Failures >= l.config.Threshold:
+			// The lock ran out: wait for the one attempt in flight.
+			if entry.Pending > 0 {
+				wait = max(wait, l.delay(entry.Failures+1-l.config.Threshold))
+			}
+		case entry.Failures+entry.Pending >= l.config.Threshold:
+			// The attempts in flight would lock the key if they fail.
+			wait = max(wait, l.delay(entry.Failures+entry.Pending-l.config.Threshold))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			entry = &LockoutStatus{Key: key}
+			l.entries[key] = entry
+		}
+		entry.Pending++
+	}
+	return 0
+}
+
+// Failure records a failed attempt and returns the resulting lockout, if any.
+func (l *Lockout) Failure(ip, username string) time.Duration {
+	if l.allowed(ip) {
+		return 0
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range lockoutKeys(ip, username) {
+		entry, ok := l.entries[key]
+		if !ok {
+			entry = &LockoutStatus{Key: key}
+			l.entries[key] = entry
+		}
+		l.expire(entry, now)
+		if entry.Pending > 0 {
+			entry.Pending--
+		}
+		entry.Failures++
+		entry.LastFailure = now
+
+		if entry.Failures < l.config.Threshold {
+			continue
+		}
+		delay := l.delay(entry.Failures - l.config.Threshold)
+		entry.LockedUntil = now.Add(delay)
+		logger.Warning("Authentication locked for %s after %d failures (for %s)", key, entry.Failures, delay)
+		if delay > wait {
+			wait = delay
+		}
+	}
+	return wait
+}
+
+// Success clears the failures of the username, which has just proven its
+// password. The client IP keeps its failures until they expire; clearing
+// them would let a client with one valid account reset its counter between
+// guesses against others.
+func (l *Lockout) Success(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, "user:"+username)
+	l.release("ip:" + ip)
+}
+
+// Release returns an attempt reserved by Check without recording an
+// outcome, for requests that did not test any credentials.
+func (l *Lockout) Release(ip, username string) {
+	if l.allowed(ip) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range lockoutKeys(ip, username) {
+		l.release(key)
+	}
+}
+
+func (l *Lockout) release(key string) {
+	entry, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	if entry.Pending > 0 {
+		entry.Pending--
+	}
+	if entry.Pending == 0 && entry.Failures == 0 {
+		delete(l.entries, key)
+	}
+}
+
+// expire forgets failures older than the window.
+func (l *Lockout) expire(entry *LockoutStatus, now time.Time) {
+	if entry.Failures > 0 && now.Sub(entry.LastFailure) > l.config.Window {
+		entry.Failures = 0
+	}
+}
+
+func (l *Lockout) delay(excess int) time.Duration {
+	delay := l.config.BaseDelay
+	for i := 0; i < excess && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	return delay
+}
+
+// purge drops forgotten entries at most once a minute, keeping the map
+// bounded by the clients seen within the window.
+func (l *Lockout) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < time.Minute {
+		return
+	}
+	l.lastPurge = now
+	for key, entry := range l.entries {
+		if entry.Pending == 0 && !entry.Locked(now) && now.Sub(entry.LastFailure) > l.config.Window {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Status returns a snapshot of all tracked clients and usernames, sorted by
+// key.
+func (l *Lockout) Status() []LockoutStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]LockoutStatus, 0, len(l.entries))
+	for _, entry := range l.entries {
+		statuses = append(statuses, *entry)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Key < statuses[j].Key
+	})
+	return statuses
+}
+
+// Locked returns the keys that are currently locked out.
+func (l *Lockout) Locked() []LockoutStatus {
+	now := l.now()
+	var locked []LockoutStatus
+	for _, status := range l.Status() {
+		if status.Locked(now) {
+			locked = append(locked, status)
+		}
+	}
+	return locked
+}
+
+// Unlock clears a key such as "ip:192.0.2.1" or "user:alice". It reports
+// whether the key was tracked.
+func (l *Lockout) Unlock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[key]
+	delete(l.entries, key)
+	if ok {
+		logger.Info("Authentication lockout cleared for %s", key)
+	}
+	return ok
+}
+
+func lockoutKeys(ip, username string) []string {
+	keys := []string{"ip:" + ip}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+// clientIP returns the address the request came from, without the port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// ParseNetworks parses a comma-separated list of CIDRs and bare IPs.
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", item, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
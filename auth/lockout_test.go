@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLockout() *Lockout {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	return NewLockout(LockoutConfig{
+		Threshold: 3,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Window:    time.Hour,
+		Allowed:   []*net.IPNet{allowed},
+	})
+}
+
+func TestLockoutConcurrentChecksReserveAttempts(t *testing.T) {
+	l := newTestLockout()
+
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if l.Check("192.0.2.1", "alice") == 0 {
+				admitted.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := admitted.Load(); got != 3 {
+		t.Fatalf("%d concurrent attempts admitted, want the threshold of 3", got)
+	}
+	for i := 0; i < 3; i++ {
+		l.Failure("192.0.2.1", "alice")
+	}
+	if l.Check("192.0.2.1", "alice") == 0 {
+		t.Error("client not locked after the reserved attempts failed")
+	}
+}
+
+func TestLockoutSettlesReservations(t *testing.T) {
+	tests := []struct {
+		name       string
+		ip         string
+		settle     func(l *Lockout, ip string)
+		wantKeys   int
+		wantLocked bool
+	}{
+		{"success clears", "192.0.2.1", func(l *Lockout, ip string) { l.Success(ip, "alice") }, 0, false},
+		{"release forgets", "192.0.2.1", func(l *Lockout, ip string) { l.Release(ip, "alice") }, 0, false},
+		{"failures lock", "192.0.2.1", func(l *Lockout, ip string) { l.Failure(ip, "alice") }, 2, true},
+		{"allowed clients are not tracked", "10.1.2.3", func(l *Lockout, ip string) { l.Failure(ip, "alice") }, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLockout()
+			for i := 0; i < 3; i++ {
+				if wait := l.Check(tt.ip, "alice"); wait != 0 {
+					t.Fatalf("attempt %d rejected for %s", i+1, wait)
+				}
+				tt.settle(l, tt.ip)
+			}
+
+			if got := len(l.Status()); got != tt.wantKeys {
+				t.Errorf("%d keys tracked, want %d", got, tt.wantKeys)
+			}
+			if locked := l.Check(tt.ip, "alice") > 0; locked != tt.wantLocked {
+				t.Errorf("locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestLockoutReservationLimitsPerUsername(t *testing.T) {
+	l := newTestLockout()
+	for i := 0; i < 3; i++ {
+		if l.Check("192.0.2.1", "bob") != 0 {
+			t.Fatalf("attempt %d rejected", i+1)
+		}
+	}
+	// A different client guessing the same username waits for the attempts
+	// already in flight.
+	if l.Check("198.51.100.7", "bob") == 0 {
+		t.Error("reservations for the username were not counted")
+	}
+	l.Success("192.0.2.1", "bob")
+	if l.Check("198.51.100.7", "bob") != 0 {
+		t.Error("username still limited after a successful login")
+	}
+}
+
+func TestLockoutAdmitsOneAttemptAfterLockExpires(t *testing.T) {
+	l := newTestLockout()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if wait := l.Check("192.0.2.1", ""); wait != 0 {
+			t.Fatalf("attempt %d rejected for %s", i+1, wait)
+		}
+		l.Failure("192.0.2.1", "")
+	}
+	if wait := l.Check("192.0.2.1", ""); wait != time.Second {
+		t.Fatalf("Check() = %s while locked, want the base delay", wait)
+	}
+
+	now = now.Add(time.Second + time.Millisecond)
+	if wait := l.Check("192.0.2.1", ""); wait != 0 {
+		t.Fatalf("Check() = %s after the lock expired, want one attempt admitted", wait)
+	}
+	if wait := l.Check("192.0.2.1", ""); wait == 0 {
+		t.Fatal("a second attempt was admitted while the first is pending")
+	}
+	if wait := l.Failure("192.0.2.1", ""); wait != 2*time.Second {
+		t.Fatalf("Failure() = %s, want the delay doubled to 2s", wait)
+	}
+	if wait := l.Check("192.0.2.1", ""); wait != 2*time.Second {
+		t.Errorf("Check() = %s, want 2s", wait)
+	}
+}
+
+func TestLockoutSuccessKeepsIPFailures(t *testing.T) {
+	l := newTestLockout()
+	for _, user := range []string{"alice", "bob"} {
+		if l.Check("192.0.2.1", user) != 0 {
+			t.Fatalf("guess against %s rejected", user)
+		}
+		l.Failure("192.0.2.1", user)
+	}
+
+	// A valid login to another account must not reset the client's counter.
+	if l.Check("192.0.2.1", "mallory") != 0 {
+		t.Fatal("login rejected")
+	}
+	l.Success("192.0.2.1", "mallory")
+
+	if l.Check("192.0.2.1", "carol") != 0 {
+		t.Fatal("third guess rejected before the threshold")
+	}
+	if wait := l.Failure("192.0.2.1", "carol"); wait == 0 {
+		t.Error("client not locked after three failures spanning a successful login")
+	}
+	for _, status := range l.Status() {
+		if status.Key == "user:mallory" {
+			t.Error("successful username still tracked")
+		}
+	}
+}
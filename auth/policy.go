@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// AnyOf accepts a request when at least one of its methods does.
+type AnyOf struct {
+	Methods []AuthMethod
+}
+
+func (a *AnyOf) Authenticate(req *http.Request, mode Mode) *Principal {
+	for _, method := range a.Methods {
+		if principal := method.Authenticate(req, mode); principal != nil {
+			return principal
+		}
+	}
+	return nil
+}
+
+func (a *AnyOf) IdentityHeaders() []string {
+	return identityHeaders(a.Methods)
+}
+
+// Challenge offers every scheme the client could satisfy.
+func (a *AnyOf) Challenge() string {
+	var challenges []string
+	for _, method := range a.Methods {
+		if challenge := method.Challenge(); challenge != "" {
+			challenges = append(challenges, challenge)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// AllOf accepts a request only when every one of its methods does. The
+// resulting principal merges what each method proved.
+type AllOf struct {
+	Methods []AuthMethod
+}
+
+func (a *AllOf) Authenticate(req *http.Request, mode Mode) *Principal {
+	if len(a.Methods) == 0 {
+		return nil
+	}
+	var principals []*Principal
+	for _, method := range a.Methods {
+		principal := method.Authenticate(req, mode)
+		if principal == nil {
+			return nil
+		}
+		principals = append(principals, principal)
+	}
+	return mergePrincipals(principals)
+}
+
+func (a *AllOf) IdentityHeaders() []string {
+	return identityHeaders(a.Methods)
+}
+
+func (a *AllOf) Challenge() string {
+	for _, method := range a.Methods {
+		if challenge := method.Challenge(); challenge != "" {
+			return challenge
+		}
+	}
+	return ""
+}
+
+// consumesCredentials reports whether method reads the credentials header,
+// which decides if the header is Groxy's to strip in reverse proxy mode.
+func consumesCredentials(method AuthMethod) bool {
+	switch m := method.(type) {
+	case nil, *NoAuth, *ClientCertAuth:
+		return false
+	case *AnyOf:
+		for _, child := range m.Methods {
+			if consumesCredentials(child) {
+				return true
+			}
+		}
+		return false
+	case *AllOf:
+		for _, child := range m.Methods {
+			if consumesCredentials(child) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// requiresCredentials reports whether method can reject a request, i.e.
+// whether passing it proves anything about the client.
+func requiresCredentials(method AuthMethod) bool {
+	switch m := method.(type) {
+	case nil, *NoAuth:
+		return false
+	case *AnyOf:
+		for _, child := range m.Methods {
+			if !requiresCredentials(child) {
+				return false
+			}
+		}
+		return len(m.Methods) > 0
+	case *AllOf:
+		for _, child := range m.Methods {
+			if requiresCredentials(child) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// Policy is a named method, so denials can be traced back to the
+// configuration that caused them.
+type Policy struct {
+	Name   string
+	Method AuthMethod
+}
+
+// Route applies Policy to requests for Host (any host when empty) whose path
+// is PathPrefix or lies below it. Paths are cleaned before matching and
+// prefixes only match whole segments, so "/health" covers "/health/live" but
+// neither "/healthz" nor "/health/../admin".
+type Route struct {
+	Host       string
+	PathPrefix string
+	Policy     *Policy
+}
+
+// PolicyRouter picks the policy for a request. Routes for a specific host win
+// over host-less ones, and a longer path prefix wins over a shorter one.
+// Requests matching no route get Default.
+type PolicyRouter struct {
+	Routes  []Route
+	Default *Policy
+}
+
+func (r *PolicyRouter) Select(req *http.Request) *Policy {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	reqPath := cleanPath(req.URL.Path)
+
+	var best *Route
+	for i := range r.Routes {
+		route := &r.Routes[i]
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if !prefixMatches(reqPath, route.PathPrefix) {
+			continue
+		}
+		if best == nil || routeBeats(route, best) {
+			best = route
+		}
+	}
+
+	if best != nil {
+		return best.Policy
+	}
+	return r.Default
+}
+
+// cleanPath resolves dot segments and duplicate slashes the way the upstream
+// will, so a route cannot be picked by a path that ends up elsewhere.
+func cleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// prefixMatches reports whether the cleaned path p is prefix or lies below
+// it on a segment boundary.
+func prefixMatches(p, prefix string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if strings.HasSuffix(prefix, "/") {
+		return p == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(p, prefix)
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+func routeBeats(route, other *Route) bool {
+	if (route.Host != "") != (other.Host != "") {
+		return route.Host != ""
+	}
+	return len(route.PathPrefix) > len(other.PathPrefix)
+}
+
+type policyConfig struct {
+	Method string   `json:"method"`
+	AnyOf  []string `json:"any_of"`
+	AllOf  []string `json:"all_of"`
+	// Scopes overrides the scopes a method requires, for methods that
+	// support it.
+	Scopes []string `json:"scopes"`
+}
+
+// scopedMethod is implemented by methods whose required scopes can differ
+// between policies.
+type scopedMethod interface {
+	WithScopes(scopes []string) AuthMethod
+}
+
+type routeConfig struct {
+	Host       string `json:"host"`
+	PathPrefix string `json:"path_prefix"`
+	Policy     string `json:"policy"`
+}
+
+type policyFile struct {
+	Policies map[string]policyConfig `json:"policies"`
+	Routes   []routeConfig           `json:"routes"`
+	Default  string                  `json:"default"`
+}
+
+// LoadPolicies reads a policy file. Policies are built from method names,
+// resolved through newMethod, or from other policies via any_of / all_of:
+//
+//	{
+//	  "policies": {
+//	    "public": {"method": "none"},
+//	    "user":   {"any_of": ["token", "basic"]},
+//	    "admin":  {"all_of": ["mtls", "token"]},
+//	    "write":  {"method": "introspection", "scopes": ["write"]}
+//	  },
+//	  "routes": [
+//	    {"path_prefix": "/health", "policy": "public"},
+//	    {"host": "admin.example.com", "path_prefix": "/", "policy": "admin"}
+//	  ],
+//	  "default": "user"
+//	}
+func LoadPolicies(path string, newMethod func(name string) (AuthMethod, error)) (*PolicyRouter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %v", err)
+	}
+
+	builder := &policyBuilder{
+		configs:   file.Policies,
+		newMethod: newMethod,
+		policies:  make(map[string]*Policy),
+		building:  make(map[string]bool),
+	}
+
+	router := &PolicyRouter{}
+	for i, rc := range file.Routes {
+		if rc.Policy == "" {
+			return nil, fmt.Errorf("route %d has no policy", i)
+		}
+		policy, err := builder.policy(rc.Policy)
+		if err != nil {
+			return nil, err
+		}
+		router.Routes = append(router.Routes, Route{
+			Host:       rc.Host,
+			PathPrefix: rc.PathPrefix,
+			Policy:     policy,
+		})
+	}
+
+	if file.Default == "" {
+		return nil, fmt.Errorf("policy file has no default policy")
+	}
+	if router.Default, err = builder.policy(file.Default); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+type policyBuilder struct {
+	configs   map[string]policyConfig
+	newMethod func(name string) (AuthMethod, error)
+	policies  map[string]*Policy
+	building  map[string]bool
+}
+
+func (b *policyBuilder) policy(name string) (*Policy, error) {
+	if policy, ok := b.policies[name]; ok {
+		return policy, nil
+	}
+	config, ok := b.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %q", name)
+	}
+	if b.building[name] {
+		return nil, fmt.Errorf("policy %q refers to itself", name)
+	}
+	b.building[name] = true
+	defer delete(b.building, name)
+
+	var method AuthMethod
+	var err error
+	switch {
+	case config.Method != "" && config.AnyOf == nil && config.AllOf == nil:
+		method, err = b.newMethod(config.Method)
+		if err == nil && config.Scopes != nil {
+			scoped, ok := method.(scopedMethod)
+			if !ok {
+				return nil, fmt.Errorf("policy %q: method %s does not support scopes", name, config.Method)
+			}
+			method = scoped.WithScopes(config.Scopes)
+		}
+	case config.AnyOf != nil && config.Method == "" && config.AllOf == nil:
+		var methods []AuthMethod
+		if methods, err = b.items(config.AnyOf); err == nil {
+			method = &AnyOf{Methods: methods}
+		}
+	case config.AllOf != nil && config.Method == "" && config.AnyOf == nil:
+		var methods []AuthMethod
+		if methods, err = b.items(config.AllOf); err == nil {
+			method = &AllOf{Methods: methods}
+		}
+	default:
+		return nil, fmt.Errorf("policy %q must set exactly one of method, any_of or all_of", name)
+	}
+	if config.Scopes != nil && config.Method == "" {
+		return nil, fmt.Errorf("policy %q: scopes require a single method", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %v", name, err)
+	}
+
+	policy := &Policy{Name: name, Method: method}
+	b.policies[name] = policy
+	return policy, nil
+}
+
+// items resolves combinator entries, which name either another policy or a
+// method.
+func (b *policyBuilder) items(names []string) ([]AuthMethod, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty combinator")
+	}
+	methods := make([]AuthMethod, 0, len(names))
+	for _, name := range names {
+		if _, ok := b.configs[name]; ok {
+			policy, err := b.policy(name)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, policy.Method)
+			continue
+		}
+		method, err := b.newMethod(name)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	return methods, nil
+}
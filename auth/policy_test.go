@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicyRouterSelect(t *testing.T) {
+	public := &Policy{Name: "public", Method: &NoAuth{}}
+	static := &Policy{Name: "static", Method: &NoAuth{}}
+	admin := &Policy{Name: "admin", Method: &NoAuth{}}
+	internal := &Policy{Name: "internal", Method: &NoAuth{}}
+	user := &Policy{Name: "user", Method: &NoAuth{}}
+
+	router := &PolicyRouter{
+		Routes: []Route{
+			{PathPrefix: "/health", Policy: public},
+			{PathPrefix: "/static/", Policy: static},
+			{PathPrefix: "/admin", Policy: admin},
+			{Host: "internal.example.com", PathPrefix: "/", Policy: internal},
+		},
+		Default: user,
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   *Policy
+	}{
+		{"exact prefix", "http://example.com/health", public},
+		{"trailing slash", "http://example.com/health/", public},
+		{"below prefix", "http://example.com/health/live", public},
+		{"dot segments", "http://example.com/health/../admin", admin},
+		{"encoded dot segments", "http://example.com/health/%2e%2e/admin", admin},
+		{"encoded slashes", "http://example.com/health%2f..%2fadmin", admin},
+		{"dot segments to default", "http://example.com/health/../account", user},
+		{"not a segment boundary", "http://example.com/healthz-anything", user},
+		{"duplicate slashes", "http://example.com//health//live", public},
+		{"prefix ending in slash", "http://example.com/static/app.js", static},
+		{"prefix ending in slash without it", "http://example.com/static", static},
+		{"prefix ending in slash is not a bare prefix", "http://example.com/staticfiles", user},
+		{"escape above root", "http://example.com/../../health", public},
+		{"longest prefix", "http://example.com/admin/users", admin},
+		{"no route", "http://example.com/", user},
+		{"host route wins", "http://internal.example.com/health", internal},
+		{"host with port", "http://internal.example.com:8443/admin", internal},
+		{"host is case insensitive", "http://INTERNAL.example.com/", internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if got := router.Select(req); got != tt.want {
+				t.Errorf("Select(%s) = %s, want %s", tt.target, got.Name, tt.want.Name)
+			}
+		})
+	}
+}
+
+func TestPrefixMatches(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/anything", "", true},
+		{"/anything", "/", true},
+		{"/api", "/api", true},
+		{"/api/v1", "/api", true},
+		{"/apiv1", "/api", false},
+		{"/api-docs", "/api", false},
+		{"/api", "/api/", true},
+		{"/api/v1", "/api/", true},
+		{"/apiv1", "/api/", false},
+		{"/", "/api", false},
+	}
+
+	for _, tt := range tests {
+		if got := prefixMatches(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("prefixMatches(%q, %q) = %t, want %t", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
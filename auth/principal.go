@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Principal is the identity a method proved for a request.
+type Principal struct {
+	User   string
+	Groups []string
+	// Method names the method(s) that authenticated the request, e.g. "jwt"
+	// or "mtls+token".
+	Method string
+	Claims map[string]interface{}
+	// Headers are method-specific identity headers forwarded upstream, such
+	// as the client certificate subject or forwarded JWT claims.
+	Headers map[string]string
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by AuthModule.Authorize.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+func mergePrincipals(principals []*Principal) *Principal {
+	merged := &Principal{}
+	var methods []string
+	seenGroups := make(map[string]bool)
+	for _, principal := range principals {
+		if merged.User == "" {
+			merged.User = principal.User
+		}
+		for _, group := range principal.Groups {
+			if !seenGroups[group] {
+				seenGroups[group] = true
+				merged.Groups = append(merged.Groups, group)
+			}
+		}
+		methods = append(methods, principal.Method)
+		for claim, value := range principal.Claims {
+			if merged.Claims == nil {
+				merged.Claims = make(map[string]interface{})
+			}
+			merged.Claims[claim] = value
+		}
+		for header, value := range principal.Headers {
+			if merged.Headers == nil {
+				merged.Headers = make(map[string]string)
+			}
+			merged.Headers[header] = value
+		}
+	}
+	merged.Method = strings.Join(methods, "+")
+	return merged
+}
+
+// identityHeaderSource is implemented by methods that fill Principal.Headers.
+type identityHeaderSource interface {
+	IdentityHeaders() []string
+}
+
+func identityHeaders(methods []AuthMethod) []string {
+	var headers []string
+	for _, method := range methods {
+		if source, ok := method.(identityHeaderSource); ok {
+			headers = append(headers, source.IdentityHeaders()...)
+		}
+	}
+	return headers
+}
+
+// IdentityForwarder passes the principal upstream, either as plain headers or,
+// when TokenSecret is set, as an HS256 JWT signed by Groxy.
+type IdentityForwarder struct {
+	UserHeader   string
+	GroupsHeader string
+	TokenHeader  string
+	TokenSecret  []byte
+	TokenTTL     time.Duration
+	Issuer       string
+}
+
+func NewIdentityForwarder() *IdentityForwarder {
+	return &IdentityForwarder{
+		UserHeader:   "X-Auth-User",
+		GroupsHeader: "X-Auth-Groups",
+		TokenHeader:  "X-Auth-Token",
+		TokenTTL:     time.Minute,
+		Issuer:       "groxy",
+	}
+}
+
+func (f *IdentityForwarder) headers() []string {
+	return []string{f.UserHeader, f.GroupsHeader, f.TokenHeader}
+}
+
+func (f *IdentityForwarder) forward(req *http.Request, principal *Principal) {
+	if len(f.TokenSecret) > 0 {
+		req.Header.Set(f.TokenHeader, f.sign(principal, time.Now()))
+		return
+	}
+	if principal.User != "" {
+		req.Header.Set(f.UserHeader, principal.User)
+	}
+	if len(principal.Groups) > 0 {
+		req.Header.Set(f.GroupsHeader, strings.Join(principal.Groups, ","))
+	}
+}
+
+func (f *IdentityForwarder) sign(principal *Principal, now time.Time) string {
+	claims := map[string]interface{}{
+		"iss": f.Issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(f.TokenTTL).Unix(),
+		"amr": strings.Split(principal.Method, "+"),
+	}
+	if principal.User != "" {
+		claims["sub"] = principal.User
+	}
+	if len(principal.Groups) > 0 {
+		claims["groups"] = principal.Groups
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, f.TokenSecret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"Groxy/tls"
+)
+
+// runCACommand implements `groxy ca init`, which creates the CA used to sign
+// generated server certificates.
+func runCACommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage: groxy ca init [-cert path] [-key path] [-cn name] [-validity duration] [-key-type type] [-force]")
+	}
+
+	if len(args) < 1 || args[0] != "init" {
+		usage()
+		return 1
+	}
+
+	fs := flag.NewFlagSet("ca init", flag.ContinueOnError)
+	certFile := fs.String("cert", "certs/ca-cert.pem", "CA certificate to write")
+	keyFile := fs.String("key", "certs/ca-key.pem", "CA private key to write")
+	commonName := fs.String("cn", "Groxy CA", "Common name of the CA")
+	validity := fs.Duration("validity", 10*365*24*time.Hour, "How long the CA is valid")
+	keyType := fs.String("key-type", string(tls.KeyRSA4096), "Key type: rsa2048, rsa3072, rsa4096, p256, p384 or ed25519")
+	force := fs.Bool("force", false, "Overwrite an existing CA")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if fs.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	if !*force {
+		for _, path := range []string{*certFile, *keyFile} {
+			if _, err := os.Stat(path); err == nil {
+				fmt.Printf("Error: %s already exists, use -force to replace it\n", path)
+				return 1
+			}
+		}
+	}
+	for _, path := range []string{*certFile, *keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Printf("Failed to create directory: %v\n", err)
+			return 1
+		}
+	}
+
+	err := tls.GenerateCA(*certFile, *keyFile, tls.CAConfig{
+		CommonName:   *commonName,
+		Organization: []string{"Groxy"},
+		Validity:     *validity,
+		KeyType:      tls.KeyType(*keyType),
+	})
+	if err != nil {
+		fmt.Printf("Failed to create CA: %v\n", err)
+		return 1
+	}
+	fmt.Printf("CA written to %s and %s\n", *certFile, *keyFile)
+	return 0
+}
@@ -1,3 +1,11 @@
 module Groxy
 
 go 1.23.5
+
+require golang.org/x/crypto v0.35.0
+
+require (
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
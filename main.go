@@ -4,12 +4,17 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"Groxy/acl"
 	"Groxy/logger"
 	"Groxy/proxy"
 	"Groxy/servers"
@@ -28,10 +33,55 @@ var (
 	queueSize       int
 	timeout         int
 	enableObfuscation bool
+	obfuscationMode string
+	obfuscationSecret string
+	obfuscationPeer bool
+	encapsulate     bool
+	encapsulationPath string
+	mimicryProfile  string
+	targetsFile     string
+	padBuckets      string
+	minDelay        time.Duration
+	maxDelay        time.Duration
+	coverURL        string
+	coverInterval   time.Duration
+	certDir         string
+	clientACLFile   string
+	destinationACLFile string
+	allowPrivateDestinations bool
+	certHosts       string
+	certKeyType     string
+	certRotation    time.Duration
+	expiryThresholds string
+	expiryWebhook   string
+	expiryCommand   string
+	statusAddr      string
+	tlsPolicy       string
+	upstreamTLSPolicy string
+	tlsMinVersion   string
+	tlsMaxVersion   string
+	tlsCiphers      string
+	tlsCurves       string
+	tlsALPN         string
+	tlsNoTickets    bool
+	tlsTicketRotation time.Duration
+	acmeDomains     string
+	acmeEmail       string
+	acmeDirectory   string
+	acmeCARoot      string
+	acmeCache       string
+	acmeRenewBefore time.Duration
 	enableRedirection bool
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		os.Exit(runUserCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ca" {
+		os.Exit(runCACommand(os.Args[2:]))
+	}
+
 	flag.StringVar(&targetURLStr, "t", "", "Target URL for target-specific mode (e.g., http://10.10.10.80)")
 	flag.BoolVar(&transparent, "transparent", false, "Run in transparent mode")
 	flag.StringVar(&customHeader, "H", "", "Add a custom header (e.g., \"X-Request-ID: 12345\")")
@@ -41,6 +91,44 @@ func main() {
 	flag.IntVar(&queueSize, "queue-size", 100, "Size of the job queue for worker pool")
 	flag.IntVar(&timeout, "timeout", 30, "Timeout for requests in seconds")
 	flag.BoolVar(&enableObfuscation, "obfuscate", false, "Enable strong traffic obfuscation")
+	flag.StringVar(&obfuscationMode, "obfuscation-mode", "aes-gcm", "Obfuscation mode (aes-gcm, chacha20, compress, padding)")
+	flag.StringVar(&obfuscationSecret, "obfuscation-secret", "", "Secret shared with the obfuscation peer for key derivation")
+	flag.BoolVar(&obfuscationPeer, "obfuscation-peer", false, "Act as the receiving peer of an obfuscated link")
+	flag.BoolVar(&encapsulate, "encapsulate", false, "Carry the entire original request inside the obfuscated payload")
+	flag.StringVar(&encapsulationPath, "encapsulation-path", proxy.DefaultEncapsulationPath, "Path encapsulated requests are sent to")
+	flag.StringVar(&mimicryProfile, "mimicry", "json", "Mimicry profile for obfuscated payloads (json, multipart, png, graphql)")
+	flag.StringVar(&padBuckets, "pad-buckets", "", "Comma-separated payload size buckets for obfuscated traffic (e.g., 1024,4096,16384)")
+	flag.DurationVar(&minDelay, "min-delay", 0, "Minimum random delay before forwarding obfuscated requests")
+	flag.DurationVar(&maxDelay, "max-delay", 0, "Maximum random delay before forwarding obfuscated requests")
+	flag.StringVar(&coverURL, "cover-url", "", "Peer endpoint that receives cover requests while the link is idle")
+	flag.DurationVar(&coverInterval, "cover-interval", 30*time.Second, "Idle time before a cover request is sent")
+	flag.StringVar(&targetsFile, "targets", "", "JSON file with per-target settings")
+	flag.StringVar(&certDir, "cert-dir", "", "Directory of PEM certificate/key pairs served by SNI on the HTTPS listener")
+	flag.StringVar(&clientACLFile, "client-acl", "", "File with allow/deny CIDR rules for client addresses, checked before authentication")
+	flag.StringVar(&destinationACLFile, "destination-acl", "", "File with allow/deny CIDR rules for transparent mode destinations")
+	flag.BoolVar(&allowPrivateDestinations, "allow-private-destinations", false, "Allow transparent mode to connect to private, loopback and link-local addresses")
+	flag.StringVar(&certHosts, "cert-hosts", "localhost,127.0.0.1,::1", "Comma-separated DNS names and IP addresses for generated server certificates")
+	flag.StringVar(&certKeyType, "cert-key-type", string(tls.KeyRSA2048), "Key type for generated server certificates (rsa2048, rsa3072, rsa4096, p256, p384, ed25519)")
+	flag.DurationVar(&certRotation, "cert-rotation", 0, "How often a new server certificate is generated, replacing only certificates Groxy generated (0 only reloads certificates changed on disk)")
+	flag.StringVar(&expiryThresholds, "cert-expiry-thresholds", "720h,168h,24h", "Comma-separated times before expiry at which certificate alerts fire")
+	flag.StringVar(&expiryWebhook, "cert-expiry-webhook", "", "URL that receives certificate expiry alerts as JSON POSTs")
+	flag.StringVar(&expiryCommand, "cert-expiry-command", "", "Command run through sh -c for certificate expiry alerts, with GROXY_CERT_* variables set")
+	flag.StringVar(&statusAddr, "status-addr", "", "Address of the status API (e.g., 127.0.0.1:9090); disabled when empty")
+	flag.StringVar(&tlsPolicy, "tls-policy", "intermediate", "TLS policy profile (modern, intermediate, legacy)")
+	flag.StringVar(&upstreamTLSPolicy, "upstream-tls-policy", "", "TLS policy profile for upstream connections (defaults to -tls-policy)")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "Override the policy's minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flag.StringVar(&tlsMaxVersion, "tls-max-version", "", "Override the policy's maximum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flag.StringVar(&tlsCiphers, "tls-ciphers", "", "Comma-separated TLS 1.2 cipher suites replacing the policy's (e.g., TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)")
+	flag.StringVar(&tlsCurves, "tls-curves", "", "Comma-separated curves replacing the policy's (X25519, P256, P384, P521)")
+	flag.StringVar(&tlsALPN, "tls-alpn", "", "Comma-separated ALPN protocols offered by the HTTPS listener (e.g., http/1.1)")
+	flag.BoolVar(&tlsNoTickets, "tls-no-session-tickets", false, "Disable TLS session tickets on the HTTPS listener")
+	flag.DurationVar(&tlsTicketRotation, "tls-ticket-rotation", 24*time.Hour, "How often the TLS session ticket key is replaced")
+	flag.StringVar(&acmeDomains, "acme-domains", "", "Comma-separated domains to obtain certificates for via ACME")
+	flag.StringVar(&acmeEmail, "acme-email", "", "Contact email for the ACME account")
+	flag.StringVar(&acmeDirectory, "acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "ACME directory URL")
+	flag.StringVar(&acmeCARoot, "acme-ca-root", "", "PEM file trusted for the ACME directory's TLS certificate (e.g., Pebble)")
+	flag.StringVar(&acmeCache, "acme-cache", "certs/acme", "Directory where the ACME account key and certificates are stored")
+	flag.DurationVar(&acmeRenewBefore, "acme-renew-before", 30*24*time.Hour, "Renew ACME certificates this long before they expire")
 	flag.BoolVar(&enableRedirection, "redirect", false, "Enable HTTP to HTTPS redirection")
 	flag.Parse()
 
@@ -65,6 +153,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	if enableObfuscation && obfuscationPeer {
+		fmt.Println("Error: You cannot specify both -obfuscate and -obfuscation-peer")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if obfuscationPeer && obfuscationSecret == "" {
+		fmt.Println("Error: You must specify -obfuscation-secret when -obfuscation-peer is enabled")
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	if enableRedirection && !enableHTTPS {
 		fmt.Println("Error: You must enable HTTPS (-https) when redirection (-redirect) is enabled")
 		flag.Usage()
@@ -72,15 +172,70 @@ func main() {
 	}
 
 	authModule := auth.InitAuthFromFlags()
+	if authModule == nil {
+		fmt.Println("Error: Failed to initialize authentication, see proxy.log for details")
+		os.Exit(1)
+	}
+	if transparent {
+		authModule.SetMode(auth.ForwardProxyMode)
+	}
 	tlsConfig := tls.NewConfig("certs/server-cert.pem", "certs/server-key.pem")
+	tlsConfig.ClientCAFile = auth.ClientCAFile()
+	tlsConfig.CertConfig.DNSNames, tlsConfig.CertConfig.IPAddresses = parseHosts(certHosts)
+	if !slices.Contains(tls.KeyTypes, tls.KeyType(certKeyType)) {
+		fmt.Printf("Error: Unsupported -cert-key-type %q\n", certKeyType)
+		os.Exit(1)
+	}
+	tlsConfig.CertConfig.KeyType = tls.KeyType(certKeyType)
+	if err := setTLSPolicies(tlsConfig); err != nil {
+		fmt.Printf("Invalid TLS policy: %v\n", err)
+		os.Exit(1)
+	}
+	if tlsConfig.ClientCAFile != "" && !enableHTTPS {
+		fmt.Println("⚠️ WARNING: Client certificates require -https; plain HTTP requests will be rejected")
+	}
 	tlsManager := tls.NewManager(tlsConfig)
 
 	tlsManager.OnRotation = func(cert *cryptotls.Certificate) {
-		fmt.Println("Certificate rotated successfully")
+		logger.LogCertificateRotation()
+		fmt.Printf("Certificate rotated successfully, valid until %s\n", cert.Leaf.NotAfter.Format(time.RFC3339))
 	}
 	tlsManager.OnError = func(err error) {
+		logger.LogCertificateError(err)
 		fmt.Printf("Certificate rotation error: %v\n", err)
 	}
+	if certDir != "" {
+		if !enableHTTPS {
+			fmt.Println("⚠️ WARNING: -cert-dir only applies to the HTTPS listener (-https)")
+		}
+		certStore := tls.NewCertStore()
+		if err := certStore.LoadDirectory(certDir); err != nil {
+			fmt.Printf("Failed to load certificates: %v\n", err)
+			os.Exit(1)
+		}
+		certStore.StartWatching(10 * time.Second)
+		tlsManager.SetCertStore(certStore)
+	}
+	var acmeManager *tls.ACME
+	if acmeDomains != "" {
+		if !enableHTTPS {
+			fmt.Println("⚠️ WARNING: ACME certificates are only served on the HTTPS listener (-https)")
+		}
+		var err error
+		acmeManager, err = tls.NewACME(tls.ACMEConfig{
+			Domains:      strings.Split(acmeDomains, ","),
+			Email:        acmeEmail,
+			DirectoryURL: acmeDirectory,
+			RootCAFile:   acmeCARoot,
+			CacheDir:     acmeCache,
+			RenewBefore:  acmeRenewBefore,
+		})
+		if err != nil {
+			fmt.Printf("Failed to configure ACME: %v\n", err)
+			os.Exit(1)
+		}
+		tlsManager.SetACME(acmeManager)
+	}
 
 	var targetURL *url.URL
 	if !transparent {
@@ -92,7 +247,81 @@ func main() {
 		}
 	}
 
-	proxyHandler := proxy.NewProxy(targetURL, tlsConfig, customHeader, enableObfuscation)
+	if enableObfuscation && obfuscationSecret == "" {
+		fmt.Println("⚠️ WARNING: No -obfuscation-secret given, using random keys that no peer can decrypt")
+	}
+
+	proxyHandler := proxy.NewProxy(targetURL, tlsConfig, customHeader, enableObfuscation || obfuscationPeer)
+	if err := proxyHandler.SetObfuscationSecret(obfuscationSecret); err != nil {
+		fmt.Printf("Failed to derive obfuscation keys: %v\n", err)
+		os.Exit(1)
+	}
+	if err := proxyHandler.SetObfuscationMode(obfuscationMode); err != nil {
+		fmt.Printf("Invalid obfuscation mode: %v\n", err)
+		os.Exit(1)
+	}
+	if err := proxyHandler.SetMimicryProfile(mimicryProfile); err != nil {
+		fmt.Printf("Invalid mimicry profile: %v\n", err)
+		os.Exit(1)
+	}
+	if encapsulate {
+		proxyHandler.SetEncapsulation(encapsulationPath)
+	}
+	buckets, err := parseBuckets(padBuckets)
+	if err != nil {
+		fmt.Printf("Invalid pad buckets: %v\n", err)
+		os.Exit(1)
+	}
+	thresholds, err := parseDurations(expiryThresholds)
+	if err != nil {
+		fmt.Printf("Invalid certificate expiry thresholds: %v\n", err)
+		os.Exit(1)
+	}
+	proxyHandler.SetTrafficShaping(proxy.ShapingConfig{
+		Buckets:       buckets,
+		MinDelay:      minDelay,
+		MaxDelay:      maxDelay,
+		CoverURL:      coverURL,
+		CoverInterval: coverInterval,
+	})
+	if obfuscationPeer {
+		proxyHandler.EnablePeerMode()
+	}
+	if targetsFile != "" {
+		targets, err := proxy.LoadTargets(targetsFile)
+		if err != nil {
+			fmt.Printf("Failed to load targets: %v\n", err)
+			os.Exit(1)
+		}
+		if err := proxyHandler.SetTargets(targets); err != nil {
+			fmt.Printf("Invalid target configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if clientACLFile != "" {
+		clientACL, err := acl.New("client", clientACLFile, nil)
+		if err != nil {
+			fmt.Printf("Failed to load client ACL: %v\n", err)
+			os.Exit(1)
+		}
+		clientACL.StartWatching()
+		proxyHandler.SetClientACL(clientACL)
+	}
+	if transparent {
+		fixed := acl.MetadataRules
+		if !allowPrivateDestinations {
+			fixed = append(append([]acl.Rule{}, fixed...), acl.PrivateRules...)
+		}
+		destinationACL, err := acl.New("destination", destinationACLFile, fixed)
+		if err != nil {
+			fmt.Printf("Failed to load destination ACL: %v\n", err)
+			os.Exit(1)
+		}
+		destinationACL.StartWatching()
+		proxyHandler.SetDestinationACL(destinationACL)
+	} else if destinationACLFile != "" {
+		fmt.Println("⚠️ WARNING: -destination-acl only applies in transparent mode")
+	}
 	proxyHandler.SetAuthModule(authModule)	
 	proxyHandler.SetTimeout(time.Duration(timeout) * time.Second)
 	
@@ -110,6 +339,10 @@ func main() {
 		"8443",
 	)
 	server.SetRedirection(enableRedirection)
+	server.SetRotation(certRotation)
+	if acmeManager != nil {
+		server.SetACME(acmeManager)
+	}
 
 	if enableHTTP {
 		if err := server.StartHTTP(); err != nil {
@@ -125,6 +358,21 @@ func main() {
 		}
 		fmt.Println("HTTPS server is running on port 8443")
 	}
+	for host, cert := range proxyHandler.ClientCertificates() {
+		tlsManager.TrackClientCert("target "+host, cert)
+	}
+	tlsManager.StartExpiryMonitor(tls.ExpiryConfig{
+		Thresholds: thresholds,
+		WebhookURL: expiryWebhook,
+		Command:    expiryCommand,
+	})
+	if statusAddr != "" {
+		if err := server.StartStatus(statusAddr); err != nil {
+			fmt.Printf("Failed to start status API: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Status API is running on %s\n", statusAddr)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -146,4 +394,94 @@ func main() {
 	}
 
 	fmt.Println("Server shutdown complete")
-}
\ No newline at end of file
+}
+
+func parseBuckets(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var buckets []int
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || bucket <= 0 {
+			return nil, fmt.Errorf("%q is not a positive size", field)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// parseHosts splits a comma-separated list into DNS names and IP addresses.
+func parseHosts(value string) ([]string, []net.IP) {
+	var names []string
+	var ips []net.IP
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if ip := net.ParseIP(field); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			names = append(names, field)
+		}
+	}
+	return names, ips
+}
+
+func parseDurations(value string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("%q is not a positive duration", field)
+		}
+		durations = append(durations, duration)
+	}
+	return durations, nil
+}
+
+// setTLSPolicies builds the listener and upstream policies from the -tls-*
+// flags. Version, cipher and curve overrides apply to both; ALPN and session
+// tickets only concern the listener.
+func setTLSPolicies(config *tls.Config) error {
+	overrides := tls.PolicyOverrides{
+		MinVersion:            tlsMinVersion,
+		MaxVersion:            tlsMaxVersion,
+		CipherSuites:          splitList(tlsCiphers),
+		Curves:                splitList(tlsCurves),
+		ALPN:                  splitList(tlsALPN),
+		DisableSessionTickets: tlsNoTickets,
+	}
+	serverPolicy, err := tls.NewPolicy(tlsPolicy, overrides)
+	if err != nil {
+		return err
+	}
+	profile := upstreamTLSPolicy
+	if profile == "" {
+		profile = tlsPolicy
+	}
+	upstreamPolicy, err := tls.NewPolicy(profile, overrides)
+	if err != nil {
+		return err
+	}
+	config.ServerPolicy = serverPolicy
+	config.UpstreamPolicy = upstreamPolicy
+	config.TicketKeyRotation = tlsTicketRotation
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"Groxy/logger"
+)
+
+func (t *Target) fronted() bool {
+	return t.DialAddress != "" || t.ServerName != "" || t.HostHeader != ""
+}
+
+// applyFronting points the transport at the target's dial address and sets
+// the TLS SNI independently of the URL host.
+func applyFronting(transport *http.Transport, target *Target, dialer *net.Dialer) {
+	if target.DialAddress != "" {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, target.DialAddress)
+		}
+	}
+	if target.ServerName != "" && transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.ServerName = target.ServerName
+	}
+}
+
+// frontedHost returns the Host header to send: the configured one, or the
+// real target host so that the front does not leak into the inner request.
+func frontedHost(target *Target, targetURL *url.URL) string {
+	if target.HostHeader != "" {
+		return target.HostHeader
+	}
+	return targetURL.Host
+}
+
+func applyFrontingDirector(proxy *httputil.ReverseProxy, target *Target, targetURL *url.URL) {
+	host := frontedHost(target, targetURL)
+	front := target.ServerName
+	if front == "" {
+		front = targetURL.Hostname()
+	}
+	dial := target.DialAddress
+	if dial == "" {
+		dial = targetURL.Host
+	}
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = host
+		logger.Info("Domain fronting: front %s (dial %s), real host %s", front, dial, host)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"Groxy/tls"
+)
+
+func TestDomainFronting(t *testing.T) {
+	var gotHost, gotSNI string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotSNI = r.Host, r.TLS.ServerName
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := upstreamURL.Host
+
+	tests := []struct {
+		name     string
+		target   string
+		fronting Target
+		wantSNI  string
+		wantHost string
+	}{
+		{"dial, SNI and Host", "https://real.test", Target{DialAddress: dial, ServerName: "front.test", HostHeader: "inner.test"}, "front.test", "inner.test"},
+		{"dial only", "https://real.test", Target{DialAddress: dial}, "real.test", "real.test"},
+		{"dial and SNI", "https://real.test", Target{DialAddress: dial, ServerName: "front.test"}, "front.test", "real.test"},
+		{"dial and Host", "https://real.test", Target{DialAddress: dial, HostHeader: "inner.test"}, "real.test", "inner.test"},
+		{"no front", upstream.URL, Target{}, "", "proxy.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotHost, gotSNI = "", ""
+			targetURL, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := tt.fronting
+			target.Host = targetURL.Host
+			target.InsecureSkipVerify = true
+
+			p := NewProxy(targetURL, tls.NewConfig("", ""), "", false)
+			if err := p.SetTargets([]*Target{&target}); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			p.createReverseProxy(targetURL).ServeHTTP(rec, httptest.NewRequest("GET", "http://proxy.test/", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if gotSNI != tt.wantSNI {
+				t.Errorf("SNI = %q, want %q", gotSNI, tt.wantSNI)
+			}
+			if gotHost != tt.wantHost {
+				t.Errorf("Host = %q, want %q", gotHost, tt.wantHost)
+			}
+		})
+	}
+}
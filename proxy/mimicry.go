@@ -0,0 +1,323 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MimicryProfile wraps obfuscated payloads in something that looks like
+// ordinary application traffic.
+type MimicryProfile interface {
+	Name() string
+	// Encode wraps payload in a body and returns it with its Content-Type.
+	Encode(payload []byte) (body []byte, contentType string, err error)
+	Decode(body []byte, contentType string) ([]byte, error)
+	// RequestHeaders returns the headers a real client of this kind sends
+	// alongside the body.
+	RequestHeaders() http.Header
+}
+
+var (
+	mimicryProfiles   = make(map[string]MimicryProfile)
+	mimicryProfilesMu sync.RWMutex
+)
+
+func init() {
+	RegisterMimicryProfile(jsonProfile{})
+	RegisterMimicryProfile(multipartProfile{})
+	RegisterMimicryProfile(pngProfile{})
+	RegisterMimicryProfile(graphQLProfile{})
+}
+
+func RegisterMimicryProfile(profile MimicryProfile) {
+	mimicryProfilesMu.Lock()
+	defer mimicryProfilesMu.Unlock()
+	mimicryProfiles[strings.ToLower(profile.Name())] = profile
+}
+
+func MimicryProfileByName(name string) (MimicryProfile, error) {
+	mimicryProfilesMu.RLock()
+	defer mimicryProfilesMu.RUnlock()
+
+	if profile, ok := mimicryProfiles[strings.ToLower(name)]; ok {
+		return profile, nil
+	}
+
+	var names []string
+	for name := range mimicryProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown mimicry profile %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+func browserHeaders(accept string) http.Header {
+	header := make(http.Header)
+	header.Set("Accept", accept)
+	header.Set("Accept-Language", "en-US,en;q=0.9")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Pragma", "no-cache")
+	header.Set("Sec-Fetch-Dest", "empty")
+	header.Set("Sec-Fetch-Mode", "cors")
+	header.Set("Sec-Fetch-Site", "same-origin")
+	return header
+}
+
+// jsonProfile looks like a telemetry batch posted to a JSON API.
+type jsonProfile struct{}
+
+type jsonEnvelope struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"`
+	Data      string `json:"data"`
+	Digest    string `json:"digest"`
+}
+
+func (jsonProfile) Name() string { return "json" }
+
+func (jsonProfile) Encode(payload []byte) ([]byte, string, error) {
+	digest := sha256.Sum256(payload)
+	body, err := json.Marshal(jsonEnvelope{
+		ID:        randomUUID(),
+		Type:      "events.batch",
+		Timestamp: time.Now().UnixMilli(),
+		Data:      base64.StdEncoding.EncodeToString(payload),
+		Digest:    base64.StdEncoding.EncodeToString(digest[:16]),
+	})
+	return body, "application/json", err
+}
+
+func (jsonProfile) Decode(body []byte, contentType string) ([]byte, error) {
+	var envelope jsonEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid json envelope: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json data field: %v", err)
+	}
+	digest, err := base64.StdEncoding.DecodeString(envelope.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json digest field: %v", err)
+	}
+	expected := sha256.Sum256(payload)
+	if subtle.ConstantTimeCompare(digest, expected[:16]) != 1 {
+		return nil, fmt.Errorf("json envelope digest mismatch")
+	}
+	return payload, nil
+}
+
+func (jsonProfile) RequestHeaders() http.Header {
+	return browserHeaders("application/json, text/plain, */*")
+}
+
+// multipartProfile looks like a browser file upload.
+type multipartProfile struct{}
+
+func (multipartProfile) Name() string { return "multipart" }
+
+func (multipartProfile) Encode(payload []byte) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	// Same shape as the boundaries browsers generate.
+	if err := w.SetBoundary("----WebKitFormBoundary" + randomString(16)); err != nil {
+		return nil, "", err
+	}
+
+	if err := w.WriteField("name", "upload-"+randomString(8)); err != nil {
+		return nil, "", err
+	}
+
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s.bin"`, randomString(12)))
+	partHeader.Set("Content-Type", "application/octet-stream")
+	part, err := w.CreatePart(partHeader)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := part.Write(payload); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+func (multipartProfile) Decode(body []byte, contentType string) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart content type: %v", err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("multipart content type has no boundary")
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("multipart body has no file part")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %v", err)
+		}
+		if part.FormName() == "file" {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+// RequestHeaders match fetch() posting a FormData, which is where the WebKit
+// boundary comes from; a form navigation would send HTML Accept headers.
+func (multipartProfile) RequestHeaders() http.Header {
+	return browserHeaders("*/*")
+}
+
+// pngProfile stores the payload as the pixels of a valid grayscale PNG.
+type pngProfile struct{}
+
+// maxPNGPixels caps the image size Decode accepts. A PNG of a few kilobytes
+// can declare dimensions that would take gigabytes to decode.
+const maxPNGPixels = maxPeerFrameSize
+
+func (pngProfile) Name() string { return "png" }
+
+func (pngProfile) Encode(payload []byte) ([]byte, string, error) {
+	size := len(payload) + 4
+	width := int(math.Ceil(math.Sqrt(float64(size))))
+	if width < 16 {
+		width = 16
+	}
+	height := (size + width - 1) / width
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	if _, err := rand.Read(img.Pix); err != nil {
+		return nil, "", err
+	}
+	binary.BigEndian.PutUint32(img.Pix, uint32(len(payload)))
+	copy(img.Pix[4:], payload)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+func (pngProfile) Decode(body []byte, contentType string) ([]byte, error) {
+	config, err := png.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid png body: %v", err)
+	}
+	if config.ColorModel != color.GrayModel {
+		return nil, fmt.Errorf("unexpected png color model")
+	}
+	if int64(config.Width)*int64(config.Height) > maxPNGPixels {
+		return nil, fmt.Errorf("png dimensions %dx%d too large", config.Width, config.Height)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid png body: %v", err)
+	}
+	img, ok := decoded.(*image.Gray)
+	if !ok {
+		return nil, fmt.Errorf("unexpected png color model")
+	}
+
+	bounds := img.Bounds()
+	pix := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := img.PixOffset(bounds.Min.X, y)
+		pix = append(pix, img.Pix[offset:offset+bounds.Dx()]...)
+	}
+	if len(pix) < 4 {
+		return nil, fmt.Errorf("png body too small")
+	}
+	size := binary.BigEndian.Uint32(pix)
+	if uint64(size) > uint64(len(pix)-4) {
+		return nil, fmt.Errorf("png payload length out of range")
+	}
+	return pix[4 : 4+size], nil
+}
+
+// RequestHeaders match a script uploading the image as the raw request body,
+// which is how fetch() sends a Blob.
+func (pngProfile) RequestHeaders() http.Header {
+	return browserHeaders("*/*")
+}
+
+// graphQLProfile looks like a GraphQL mutation carrying a document.
+type graphQLProfile struct{}
+
+type graphQLRequest struct {
+	OperationName string `json:"operationName"`
+	Variables     struct {
+		Input struct {
+			ClientMutationID string `json:"clientMutationId"`
+			Content          string `json:"content"`
+		} `json:"input"`
+	} `json:"variables"`
+	Query string `json:"query"`
+}
+
+const graphQLMutation = "mutation SyncDocument($input: SyncDocumentInput!) { syncDocument(input: $input) { id updatedAt } }"
+
+func (graphQLProfile) Name() string { return "graphql" }
+
+func (graphQLProfile) Encode(payload []byte) ([]byte, string, error) {
+	var request graphQLRequest
+	request.OperationName = "SyncDocument"
+	request.Variables.Input.ClientMutationID = randomUUID()
+	request.Variables.Input.Content = base64.StdEncoding.EncodeToString(payload)
+	request.Query = graphQLMutation
+
+	body, err := json.Marshal(request)
+	return body, "application/json", err
+}
+
+func (graphQLProfile) Decode(body []byte, contentType string) ([]byte, error) {
+	var request graphQLRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid graphql body: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(request.Variables.Input.Content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphql content: %v", err)
+	}
+	return payload, nil
+}
+
+func (graphQLProfile) RequestHeaders() http.Header {
+	return browserHeaders("application/graphql-response+json, application/json")
+}
+
+func randomUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestMimicryProfilesRoundTrip(t *testing.T) {
+	payloads := map[string][]byte{
+		"empty":  {},
+		"short":  []byte("hello"),
+		"binary": bytes.Repeat([]byte{0, 0xff, 0x10, 0x80}, 1024),
+	}
+
+	for _, name := range []string{"json", "multipart", "png", "graphql"} {
+		profile, err := MimicryProfileByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for label, payload := range payloads {
+			t.Run(name+"/"+label, func(t *testing.T) {
+				body, contentType, err := profile.Encode(payload)
+				if err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+				got, err := profile.Decode(body, contentType)
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Errorf("Decode() = %d bytes, want %d", len(got), len(payload))
+				}
+			})
+		}
+	}
+}
+
+func TestObfuscatorRoundTripPerProfile(t *testing.T) {
+	for _, name := range []string{"json", "multipart", "png", "graphql"} {
+		t.Run(name, func(t *testing.T) {
+			profile, err := MimicryProfileByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o := NewTrafficObfuscator().WithProfile(profile)
+			body, contentType, err := o.sealFrame(frameData, []byte("secret payload"), o.requestKey)
+			if err != nil {
+				t.Fatalf("sealFrame() error = %v", err)
+			}
+			kind, data, _, err := o.openFrame(body, contentType, o.requestKey)
+			if err != nil {
+				t.Fatalf("openFrame() error = %v", err)
+			}
+			if kind != frameData || string(data) != "secret payload" {
+				t.Errorf("openFrame() = kind %d, %q", kind, data)
+			}
+		})
+	}
+}
+
+func TestMimicryRequestHeadersMatchContentType(t *testing.T) {
+	for _, name := range []string{"json", "multipart", "png", "graphql"} {
+		t.Run(name, func(t *testing.T) {
+			profile, _ := MimicryProfileByName(name)
+			_, contentType, err := profile.Encode([]byte("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			accept := profile.RequestHeaders().Get("Accept")
+			if strings.HasPrefix(contentType, "image/") && strings.Contains(accept, "application/json") {
+				t.Errorf("%s upload sent with Accept %q", contentType, accept)
+			}
+			if mode := profile.RequestHeaders().Get("Sec-Fetch-Mode"); mode == "navigate" {
+				t.Errorf("request body of %s sent as a navigation", contentType)
+			}
+		})
+	}
+}
+
+// pngWithDimensions encodes a small grayscale PNG and rewrites its IHDR to
+// claim width x height.
+func pngWithDimensions(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Signature (8), length (4), "IHDR" (4), then width and height.
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestPNGDecodeRejectsHugeDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint32
+	}{
+		{"wide", 1 << 30, 1},
+		{"square", 1 << 15, 1 << 15},
+		{"just over the cap", maxPNGPixels/1024 + 1, 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pngProfile{}.Decode(pngWithDimensions(t, tt.width, tt.height), "image/png")
+			if err == nil || !strings.Contains(err.Error(), "too large") {
+				t.Errorf("Decode() error = %v, want dimensions too large", err)
+			}
+		})
+	}
+}
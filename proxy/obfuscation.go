@@ -1,9 +1,8 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -13,22 +12,48 @@ import (
 	"io"
 	"math/big"
 	"net/http"
+	"net/url"
 //	"strings"
 	"time"
 
 	"Groxy/logger"
-)
 
-type ObfuscationMode int
+	"golang.org/x/crypto/hkdf"
+)
 
+// Every plaintext frame starts with an 8-byte timestamp followed by a kind
+// byte, so the receiver can drop cover traffic and tell bare bodies from
+// encapsulated requests and responses.
 const (
-	StrongObfuscation ObfuscationMode = iota
+	frameData     byte = 0
+	frameCover    byte = 1
+	frameRequest  byte = 2
+	frameResponse byte = 3
+
+	frameHeaderSize = 9
 )
 
+// MaxFrameAge is how far a request frame's timestamp may be from the
+// receiver's clock. Older frames are rejected as replays, so peers need
+// clocks synchronised to within this window.
+const MaxFrameAge = 2 * time.Minute
+
+// DefaultEncapsulationPath is where encapsulated requests are sent unless
+// configured otherwise.
+const DefaultEncapsulationPath = "/api/v1/events"
+
 type TrafficObfuscator struct {
 	requestKey  []byte
 	responseKey []byte
 	hmacKey     []byte
+	method      ObfuscationMethod
+	profile     MimicryProfile
+	shaper      *TrafficShaper
+	// encapsulationPath is the innocuous path encapsulated requests are sent
+	// to. Empty means only the body is obfuscated.
+	encapsulationPath string
+	// replays remembers recently opened request frames. Clones share it.
+	replays *replayCache
 }
 
 func NewTrafficObfuscator() *TrafficObfuscator {
@@ -46,64 +71,181 @@ func NewTrafficObfuscator() *TrafficObfuscator {
 		logger.Error("Failed to generate HMAC key: %v", err)
 	}
 	
+	method, _ := GetObfuscationMethod(StrongObfuscation)
+	profile, _ := MimicryProfileByName("json")
 	return &TrafficObfuscator{
 		requestKey:  requestKey,
 		responseKey: responseKey,
 		hmacKey:     hmacKey,
+		method:      method,
+		profile:     profile,
+		replays:     newReplayCache(MaxFrameAge),
+	}
+}
+
+// WithSecret returns an obfuscator whose keys are derived from a secret shared
+// with the peer, so the peer can open what t seals and vice versa.
+func (t *TrafficObfuscator) WithSecret(secret []byte) (*TrafficObfuscator, error) {
+	clone := *t
+	keys := []*[]byte{&clone.requestKey, &clone.responseKey, &clone.hmacKey}
+	labels := []string{"groxy request key", "groxy response key", "groxy hmac key"}
+	sizes := []int{32, 32, 64}
+
+	for i, key := range keys {
+		*key = make([]byte, sizes[i])
+		kdf := hkdf.New(sha256.New, secret, nil, []byte(labels[i]))
+		if _, err := io.ReadFull(kdf, *key); err != nil {
+			return nil, fmt.Errorf("failed to derive %s: %v", labels[i], err)
+		}
 	}
+	return &clone, nil
+}
+
+// WithMethod returns an obfuscator that shares t's keys but seals outgoing
+// payloads with method.
+func (t *TrafficObfuscator) WithMethod(method ObfuscationMethod) *TrafficObfuscator {
+	clone := *t
+	clone.method = method
+	return &clone
+}
+
+func (t *TrafficObfuscator) Method() ObfuscationMethod {
+	return t.method
+}
+
+// WithProfile returns an obfuscator that shares t's keys but disguises
+// payloads with profile.
+func (t *TrafficObfuscator) WithProfile(profile MimicryProfile) *TrafficObfuscator {
+	clone := *t
+	clone.profile = profile
+	return &clone
+}
+
+func (t *TrafficObfuscator) Profile() MimicryProfile {
+	return t.profile
+}
+
+// WithShaper returns an obfuscator that shares t's keys but pads payloads
+// with shaper's buckets.
+func (t *TrafficObfuscator) WithShaper(shaper *TrafficShaper) *TrafficObfuscator {
+	clone := *t
+	clone.shaper = shaper
+	return &clone
+}
+
+func (t *TrafficObfuscator) Shaper() *TrafficShaper {
+	return t.shaper
+}
+
+// WithEncapsulation returns an obfuscator that shares t's keys but carries
+// the entire original request inside the payload and sends it to path.
+func (t *TrafficObfuscator) WithEncapsulation(path string) *TrafficObfuscator {
+	clone := *t
+	clone.encapsulationPath = path
+	return &clone
 }
 
 func (t *TrafficObfuscator) ApplyToRequest(req *http.Request) error {
+	return t.applyFrame(req, frameData)
+}
+
+func (t *TrafficObfuscator) applyFrame(req *http.Request, kind byte) error {
 	if req.Body == nil {
 		req.Body = io.NopCloser(bytes.NewReader([]byte{}))
 	}
 
-	bodyBytes, err := io.ReadAll(req.Body)
-	if err != nil {
-		return err
+	method, target := req.Method, req.URL.String()
+
+	var plaintext []byte
+	if kind == frameData && t.encapsulationPath != "" {
+		// Method, absolute URL, headers and body all go inside the payload;
+		// the outer request is a POST to the fixed path.
+		var buf bytes.Buffer
+		if err := req.WriteProxy(&buf); err != nil {
+			return err
+		}
+		plaintext = buf.Bytes()
+		kind = frameRequest
+
+		outer := &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: t.encapsulationPath}
+		method, target = http.MethodPost, outer.String()
+	} else {
+		bodyBytes, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body.Close()
+		plaintext = bodyBytes
 	}
-	req.Body.Close()
 
-	timestamp := make([]byte, 8)
-	binary.BigEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()))
-	combinedData := append(timestamp, bodyBytes...)
-
-	encryptedBody, err := t.encryptData(combinedData, t.requestKey)
+	body, contentType, err := t.sealFrame(kind, plaintext, t.requestKey)
 	if err != nil {
 		return err
 	}
 
-	hmac := t.generateHMAC(encryptedBody)
-	finalPayload := append(hmac, encryptedBody...)
-	jitteredPayload := t.addJitter(finalPayload)
-
-	newReq, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(jitteredPayload))
+	newReq, err := http.NewRequestWithContext(req.Context(), method, target, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
 	newReq.Host = req.Host
-	t.obfuscateHeaders(newReq)
+	t.applyProfileHeaders(newReq, req.Header, contentType)
 	*req = *newReq
 
 	return nil
 }
 
-func (t *TrafficObfuscator) obfuscateHeaders(req *http.Request) {
-	newHeaders := make(http.Header)
+// sealFrame builds the frame header, seals it with key, pads it and wraps
+// it in the mimicry profile.
+func (t *TrafficObfuscator) sealFrame(kind byte, data []byte, key []byte) ([]byte, string, error) {
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(time.Now().UnixNano()))
+	header[8] = kind
+	combinedData := append(header, data...)
+
+	finalPayload, err := t.seal(combinedData, key)
+	if err != nil {
+		return nil, "", err
+	}
+	jitteredPayload := t.addJitter(finalPayload)
+
+	return t.profile.Encode(jitteredPayload)
+}
 
-	noiseHeaders := []string{
-		"X-Proxy-Token",
-		"X-Connection-Hash",
-		"X-Routing-Key",
-		"X-Timestamp",
+// openFrame reverses sealFrame. It returns the frame kind and data along
+// with the whole decrypted frame, whose timestamp is in the first 8 bytes.
+func (t *TrafficObfuscator) openFrame(body []byte, contentType string, key []byte) (byte, []byte, []byte, error) {
+	payload, err := t.profile.Decode(body, contentType)
+	if err != nil {
+		return 0, nil, nil, err
 	}
 
-	for _, header := range noiseHeaders {
-		newHeaders.Set(header, t.generateRandomString(32))
+	dejitteredBody, err := t.removeJitter(payload)
+	if err != nil {
+		return 0, nil, nil, err
 	}
 
-	newHeaders.Set("Content-Type", "application/octet-stream")
+	decryptedBody, err := t.open(dejitteredBody, key)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if len(decryptedBody) < frameHeaderSize {
+		return 0, nil, nil, fmt.Errorf("decrypted data too short")
+	}
+	return decryptedBody[8], decryptedBody[frameHeaderSize:], decryptedBody, nil
+}
+
+// applyProfileHeaders replaces the original headers with the profile's
+// header set. The User-Agent is kept so it stays consistent with the rotation
+// applied in ModifyRequest.
+func (t *TrafficObfuscator) applyProfileHeaders(req *http.Request, original http.Header, contentType string) {
+	newHeaders := t.profile.RequestHeaders()
+
+	if userAgent := original.Get("User-Agent"); userAgent != "" {
+		newHeaders.Set("User-Agent", userAgent)
+	}
+	newHeaders.Set("Content-Type", contentType)
 
 	req.Header = newHeaders
 }
@@ -115,45 +257,131 @@ func (t *TrafficObfuscator) ExtractFromResponse(res *http.Response) ([]byte, err
 	}
 	res.Body.Close()
 
-	dejitteredBody := t.removeJitter(bodyBytes)
+	_, data, _, err := t.openFrame(bodyBytes, res.Header.Get("Content-Type"), t.responseKey)
+	return data, err
+}
+
+// RestoreResponse replaces res with what the peer sealed into it. For
+// encapsulated exchanges that includes the original status and headers.
+func (t *TrafficObfuscator) RestoreResponse(res *http.Response) error {
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	kind, data, _, err := t.openFrame(bodyBytes, res.Header.Get("Content-Type"), t.responseKey)
+	if err != nil {
+		res.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		return err
+	}
 
-	if len(dejitteredBody) < sha256.Size+1 {
-		return nil, fmt.Errorf("response too short for decryption")
+	if kind == frameResponse {
+		inner, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), res.Request)
+		if err != nil {
+			res.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			return fmt.Errorf("failed to parse encapsulated response: %v", err)
+		}
+		data, err = io.ReadAll(inner.Body)
+		inner.Body.Close()
+		if err != nil {
+			res.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			return fmt.Errorf("failed to read encapsulated response body: %v", err)
+		}
+		res.Status = inner.Status
+		res.StatusCode = inner.StatusCode
+		res.Header = inner.Header
 	}
 
-	receivedHmac := dejitteredBody[:sha256.Size]
-	encryptedData := dejitteredBody[sha256.Size:]
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	res.ContentLength = int64(len(data))
+	res.TransferEncoding = nil
+	res.Header.Del("Transfer-Encoding")
+	res.Header.Set("Content-Length", fmt.Sprint(len(data)))
+	return nil
+}
 
-	if !t.verifyHMAC(encryptedData, receivedHmac) {
-		return nil, fmt.Errorf("HMAC verification failed")
+// ExtractRequest reverses ApplyToRequest on the receiving peer. It returns
+// the request to forward and the frame kind; cover frames return no request.
+// Frames older than MaxFrameAge, or seen before, are rejected.
+func (t *TrafficObfuscator) ExtractRequest(req *http.Request) (*http.Request, byte, error) {
+	if req.Body == nil {
+		return nil, 0, fmt.Errorf("request has no body")
+	}
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, 0, err
 	}
+	req.Body.Close()
 
-	decryptedBody, err := t.decryptData(encryptedData, t.responseKey)
+	kind, data, frame, err := t.openFrame(bodyBytes, req.Header.Get("Content-Type"), t.requestKey)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	if err := t.checkFresh(frame); err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case frameCover:
+		return nil, kind, nil
+	case frameRequest:
+		inner, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse encapsulated request: %v", err)
+		}
+		inner = inner.WithContext(req.Context())
+		inner.RemoteAddr = req.RemoteAddr
+		inner.TLS = req.TLS
+		return inner, kind, nil
+	case frameData:
+		inner := req.Clone(req.Context())
+		inner.Body = io.NopCloser(bytes.NewReader(data))
+		inner.ContentLength = int64(len(data))
+		inner.Header.Del("Content-Type")
+		return inner, kind, nil
+	default:
+		return nil, 0, fmt.Errorf("unexpected frame kind %d", kind)
 	}
+}
 
-	if len(decryptedBody) < 8 {
-		return nil, fmt.Errorf("decrypted data too short")
+// seal prefixes the payload with the mode identifier so the receiver can
+// dispatch on it, and binds that identifier into the method's integrity check.
+func (t *TrafficObfuscator) seal(data []byte, key []byte) ([]byte, error) {
+	header := []byte{byte(t.method.Mode())}
+	sealed, err := t.method.Seal(ObfuscationKey{Cipher: key, MAC: t.hmacKey}, data, header)
+	if err != nil {
+		return nil, err
 	}
-	return decryptedBody[8:], nil
+	return append(header, sealed...), nil
 }
 
-func (t *TrafficObfuscator) generateHMAC(data []byte) []byte {
-	mac := hmac.New(sha256.New, t.hmacKey)
-	mac.Write(data)
-	return mac.Sum(nil)
+func (t *TrafficObfuscator) open(data []byte, key []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("payload too short for decryption")
+	}
+
+	mode := ObfuscationMode(data[0])
+	method, ok := GetObfuscationMethod(mode)
+	if !ok {
+		return nil, fmt.Errorf("unknown obfuscation mode %d", mode)
+	}
+	// Padding payloads carry no integrity check, so never let a peer
+	// downgrade an encrypted link to them.
+	if mode == PaddingObfuscation && t.method.Mode() != PaddingObfuscation {
+		return nil, fmt.Errorf("refusing unencrypted %s payload", method.Name())
+	}
+
+	return method.Open(ObfuscationKey{Cipher: key, MAC: t.hmacKey}, data[1:], data[:1])
 }
 
-func (t *TrafficObfuscator) verifyHMAC(data []byte, expectedHmac []byte) bool {
+func verifyHMAC(key []byte, header, data []byte, expectedHmac []byte) bool {
     if len(expectedHmac) != sha256.Size {
         logger.Debug("HMAC verification failed: incorrect length")
         return false
     }
 
-    mac := hmac.New(sha256.New, t.hmacKey)
-    mac.Write(data)
-    calculated := mac.Sum(nil)
+    calculated := generateHMAC(key, header, data)
 
     match := hmac.Equal(calculated, expectedHmac)
 
@@ -167,68 +395,35 @@ func (t *TrafficObfuscator) verifyHMAC(data []byte, expectedHmac []byte) bool {
     return match
 }
 
-func (t *TrafficObfuscator) encryptData(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, err
-	}
-	
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
-}
+// addJitter frames data as a 4-byte length, the data and trailing random
+// padding. With a shaper the padding fills the frame up to a size bucket.
+func (t *TrafficObfuscator) addJitter(data []byte) []byte {
+	framedSize := 4 + len(data)
 
-func (t *TrafficObfuscator) decryptData(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
+	jitterSize := t.randomJitterSize(100, 500)
+	if t.shaper != nil && len(t.shaper.config.Buckets) > 0 {
+		jitterSize = t.shaper.paddedSize(framedSize) - framedSize
 	}
-	
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	
-	return gcm.Open(nil, nonce, ciphertext, nil)
-}
 
-func (t *TrafficObfuscator) addJitter(data []byte) []byte {
-	jitterSize := t.randomJitterSize(100, 500)
-	jitter := make([]byte, jitterSize)
-	rand.Read(jitter)
-	size := make([]byte, 4)
-	binary.BigEndian.PutUint32(size, uint32(len(data)))
+	frame := make([]byte, framedSize+jitterSize)
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	rand.Read(frame[framedSize:])
 
-	return append(append(size, jitter...), data...)
+	return frame
 }
 
-func (t *TrafficObfuscator) removeJitter(data []byte) []byte {
+func (t *TrafficObfuscator) removeJitter(data []byte) ([]byte, error) {
 	if len(data) < 4 {
-		return data
+		return nil, fmt.Errorf("payload too short for framing")
 	}
 	
 	size := binary.BigEndian.Uint32(data[:4])
 	
-	if len(data) < int(4 + size) {
-		return data
+	if uint64(size) > uint64(len(data)-4) {
+		return nil, fmt.Errorf("payload length out of range")
 	}
-	return data[4+len(data)-int(size):]
+	return data[4 : 4+size], nil
 }
 
 func (t *TrafficObfuscator) randomJitterSize(min, max int) int {
@@ -241,7 +436,7 @@ func (t *TrafficObfuscator) randomJitterSize(min, max int) int {
 	return min + int(n.Int64())
 }
 
-func (t *TrafficObfuscator) generateRandomString(length int) string {
+func randomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, length)
 	for i := range result {
@@ -249,4 +444,17 @@ func (t *TrafficObfuscator) generateRandomString(length int) string {
 		result[i] = charset[n.Int64()]
 	}
 	return string(result)
-}
\ No newline at end of file
+}
+
+// checkFresh rejects a decrypted frame whose timestamp is outside
+// MaxFrameAge or that has already been opened.
+func (t *TrafficObfuscator) checkFresh(frame []byte) error {
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(frame)))
+	if age := time.Since(sent); age > MaxFrameAge || age < -MaxFrameAge {
+		return fmt.Errorf("frame timestamp %s is outside the %s window", sent.Format(time.RFC3339), MaxFrameAge)
+	}
+	if t.replays != nil && !t.replays.add(sha256.Sum256(frame), sent) {
+		return fmt.Errorf("replayed frame")
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type ObfuscationMode byte
+
+const (
+	StrongObfuscation ObfuscationMode = iota
+	ChaChaObfuscation
+	CompressedObfuscation
+	PaddingObfuscation
+)
+
+// ObfuscationKey is the key material for one direction of the link.
+type ObfuscationKey struct {
+	Cipher []byte
+	MAC    []byte
+}
+
+// ObfuscationMethod seals and opens payloads for a single mode. The header
+// carries the mode identifier; methods that can authenticate it must do so.
+type ObfuscationMethod interface {
+	Mode() ObfuscationMode
+	Name() string
+	Seal(key ObfuscationKey, plaintext, header []byte) ([]byte, error)
+	Open(key ObfuscationKey, payload, header []byte) ([]byte, error)
+}
+
+var (
+	obfuscationMethods   = make(map[ObfuscationMode]ObfuscationMethod)
+	obfuscationMethodsMu sync.RWMutex
+)
+
+func init() {
+	RegisterObfuscationMethod(aesGCMMethod{})
+	RegisterObfuscationMethod(chachaMethod{})
+	RegisterObfuscationMethod(compressedMethod{})
+	RegisterObfuscationMethod(paddingMethod{})
+}
+
+func RegisterObfuscationMethod(method ObfuscationMethod) {
+	obfuscationMethodsMu.Lock()
+	defer obfuscationMethodsMu.Unlock()
+	obfuscationMethods[method.Mode()] = method
+}
+
+func GetObfuscationMethod(mode ObfuscationMode) (ObfuscationMethod, bool) {
+	obfuscationMethodsMu.RLock()
+	defer obfuscationMethodsMu.RUnlock()
+	method, ok := obfuscationMethods[mode]
+	return method, ok
+}
+
+func ObfuscationMethodByName(name string) (ObfuscationMethod, error) {
+	obfuscationMethodsMu.RLock()
+	defer obfuscationMethodsMu.RUnlock()
+
+	var names []string
+	for _, method := range obfuscationMethods {
+		if strings.EqualFold(method.Name(), name) {
+			return method, nil
+		}
+		names = append(names, method.Name())
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown obfuscation mode %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// aesGCMMethod is the original AES-256-GCM mode with an HMAC-SHA256 over
+// the header and ciphertext.
+type aesGCMMethod struct{}
+
+func (aesGCMMethod) Mode() ObfuscationMode { return StrongObfuscation }
+func (aesGCMMethod) Name() string          { return "aes-gcm" }
+
+func (aesGCMMethod) Seal(key ObfuscationKey, plaintext, header []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := sealAEAD(gcm, plaintext, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(generateHMAC(key.MAC, header, ciphertext), ciphertext...), nil
+}
+
+func (aesGCMMethod) Open(key ObfuscationKey, payload, header []byte) ([]byte, error) {
+	if len(payload) < sha256.Size+1 {
+		return nil, fmt.Errorf("payload too short for decryption")
+	}
+	receivedHmac, ciphertext := payload[:sha256.Size], payload[sha256.Size:]
+	if !verifyHMAC(key.MAC, header, ciphertext, receivedHmac) {
+		return nil, fmt.Errorf("HMAC verification failed")
+	}
+
+	block, err := aes.NewCipher(key.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(gcm, ciphertext, header)
+}
+
+// chachaMethod uses ChaCha20-Poly1305, which is faster than AES-GCM on
+// hardware without AES-NI.
+type chachaMethod struct{}
+
+func (chachaMethod) Mode() ObfuscationMode { return ChaChaObfuscation }
+func (chachaMethod) Name() string          { return "chacha20" }
+
+func (chachaMethod) Seal(key ObfuscationKey, plaintext, header []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	return sealAEAD(aead, plaintext, header)
+}
+
+func (chachaMethod) Open(key ObfuscationKey, payload, header []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, payload, header)
+}
+
+// compressedMethod deflates the plaintext before handing it to the AES-GCM
+// mode. Compressing secrets next to attacker-controlled data can leak them
+// through the ciphertext length, so only use it for bulk payloads.
+type compressedMethod struct{}
+
+func (compressedMethod) Mode() ObfuscationMode { return CompressedObfuscation }
+func (compressedMethod) Name() string          { return "compress" }
+
+func (compressedMethod) Seal(key ObfuscationKey, plaintext, header []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return aesGCMMethod{}.Seal(key, buf.Bytes(), header)
+}
+
+func (compressedMethod) Open(key ObfuscationKey, payload, header []byte) ([]byte, error) {
+	compressed, err := aesGCMMethod{}.Open(key, payload, header)
+	if err != nil {
+		return nil, err
+	}
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// paddingMethod applies no cryptography at all. It exists so the framing,
+// jitter and header handling can be tested with readable payloads.
+type paddingMethod struct{}
+
+func (paddingMethod) Mode() ObfuscationMode { return PaddingObfuscation }
+func (paddingMethod) Name() string          { return "padding" }
+
+func (paddingMethod) Seal(key ObfuscationKey, plaintext, header []byte) ([]byte, error) {
+	return append([]byte(nil), plaintext...), nil
+}
+
+func (paddingMethod) Open(key ObfuscationKey, payload, header []byte) ([]byte, error) {
+	return append([]byte(nil), payload...), nil
+}
+
+func sealAEAD(aead cipher.AEAD, plaintext, header []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, header), nil
+}
+
+func openAEAD(aead cipher.AEAD, data, header []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return aead.Open(nil, nonce, ciphertext, header)
+}
+
+func generateHMAC(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestObfuscationModesRoundTrip(t *testing.T) {
+	payloads := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("secret payload")},
+		{"compressible", bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\n"), 4096)},
+	}
+	tests := []struct {
+		mode    string
+		encrypt bool
+	}{
+		{"aes-gcm", true},
+		{"chacha20", true},
+		{"compress", true},
+		{"padding", false},
+	}
+
+	for _, tt := range tests {
+		method, err := ObfuscationMethodByName(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := NewTrafficObfuscator().WithMethod(method)
+
+		for _, payload := range payloads {
+			t.Run(tt.mode+"/"+payload.name, func(t *testing.T) {
+				sealed, err := o.seal(payload.data, o.requestKey)
+				if err != nil {
+					t.Fatalf("seal() error = %v", err)
+				}
+				if ObfuscationMode(sealed[0]) != method.Mode() {
+					t.Errorf("sealed payload has mode %d, want %d", sealed[0], method.Mode())
+				}
+				if tt.encrypt && len(payload.data) > 0 && bytes.Contains(sealed, payload.data) {
+					t.Error("sealed payload contains the plaintext")
+				}
+
+				opened, err := o.open(sealed, o.requestKey)
+				if err != nil {
+					t.Fatalf("open() error = %v", err)
+				}
+				if !bytes.Equal(opened, payload.data) {
+					t.Errorf("open() returned %d bytes, want the %d sealed", len(opened), len(payload.data))
+				}
+
+				if !tt.encrypt {
+					return
+				}
+				tampered := append([]byte(nil), sealed...)
+				tampered[len(tampered)-1] ^= 0x01
+				if _, err := o.open(tampered, o.requestKey); err == nil {
+					t.Error("open() accepted a tampered payload")
+				}
+				if _, err := o.open(sealed, o.responseKey); err == nil {
+					t.Error("open() accepted a payload sealed with the other direction's key")
+				}
+			})
+		}
+	}
+}
+
+func TestOpenRefusesPaddingDowngrade(t *testing.T) {
+	padding, err := ObfuscationMethodByName("padding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := NewTrafficObfuscator()
+	frame, err := base.WithMethod(padding).seal([]byte("injected"), base.requestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		configured string
+		wantErr    bool
+	}{
+		{"aes-gcm", true},
+		{"chacha20", true},
+		{"compress", true},
+		{"padding", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.configured, func(t *testing.T) {
+			method, err := ObfuscationMethodByName(tt.configured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = base.WithMethod(method).open(frame, base.requestKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("open() of a padding frame with %s configured: error = %v, wantErr %t", tt.configured, err, tt.wantErr)
+			}
+		})
+	}
+}
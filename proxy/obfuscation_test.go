@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Groxy/acl"
+)
+
+// sealAt builds a request frame as sealFrame does, but stamped with sent.
+func sealAt(t *testing.T, o *TrafficObfuscator, sent time.Time, data []byte) *http.Request {
+	t.Helper()
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(sent.UnixNano()))
+	header[8] = frameData
+	sealed, err := o.seal(append(header, data...), o.requestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, contentType, err := o.profile.Encode(o.addJitter(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "http://example.com/upload", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func TestExtractRequestFreshness(t *testing.T) {
+	o := NewTrafficObfuscator()
+
+	tests := []struct {
+		name    string
+		sent    time.Duration
+		wantErr bool
+	}{
+		{"current", 0, false},
+		{"slightly old", -time.Minute, false},
+		{"slightly ahead", time.Minute, false},
+		{"too old", -MaxFrameAge - time.Second, true},
+		{"too far ahead", MaxFrameAge + time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := sealAt(t, o, time.Now().Add(tt.sent), []byte(tt.name))
+			inner, _, err := o.ExtractRequest(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractRequest() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			body, _ := io.ReadAll(inner.Body)
+			if string(body) != tt.name {
+				t.Errorf("body = %q, want %q", body, tt.name)
+			}
+		})
+	}
+}
+
+func TestExtractRequestRejectsReplay(t *testing.T) {
+	o := NewTrafficObfuscator()
+
+	req := httptest.NewRequest("POST", "http://example.com/upload", bytes.NewReader([]byte("payload")))
+	if err := o.ApplyToRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantErr := range []bool{false, true} {
+		replay := httptest.NewRequest("POST", "http://example.com/upload", bytes.NewReader(body))
+		replay.Header = req.Header.Clone()
+		if _, _, err := o.ExtractRequest(replay); (err != nil) != wantErr {
+			t.Fatalf("attempt %d: ExtractRequest() error = %v, wantErr %t", i+1, err, wantErr)
+		}
+	}
+}
+
+func TestPeerHandlerLimitsBody(t *testing.T) {
+	p := NewProxy(nil, nil, "", true)
+	p.EnablePeerMode()
+
+	reached := false
+	handler := p.peerHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	body := &countingReader{}
+	req := httptest.NewRequest("POST", "http://example.com/upload", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if reached || rec.Code != http.StatusNotFound {
+		t.Errorf("oversized frame: status %d, reached handler %t", rec.Code, reached)
+	}
+	if body.n > maxPeerFrameSize+bytes.MinRead {
+		t.Errorf("read %d bytes of an endless body, limit is %d", body.n, maxPeerFrameSize)
+	}
+}
+
+func TestPeerHandlerChecksClientACLFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.acl")
+	if err := os.WriteFile(path, []byte("allow 10.0.0.0/8\ndefault deny\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientACL, err := acl.New("client", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewProxy(nil, nil, "", true)
+	p.EnablePeerMode()
+	p.SetClientACL(clientACL)
+	handler := p.peerHandler(http.NotFoundHandler())
+
+	body := &countingReader{}
+	req := httptest.NewRequest("POST", "http://example.com/upload", body)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || body.n != 0 {
+		t.Errorf("denied client: status %d after reading %d bytes, want 403 without reading", rec.Code, body.n)
+	}
+}
+
+// countingReader is an endless stream of zeros that counts what was read.
+type countingReader struct {
+	n int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	clear(b)
+	c.n += len(b)
+	return len(b), nil
+}
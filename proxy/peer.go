@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"Groxy/logger"
+)
+
+// maxPeerFrameSize caps the obfuscated request body read in peer mode.
+const maxPeerFrameSize = 64 << 20
+
+// maxReplayEntries bounds the replay cache. Only frames that decrypted are
+// added, so filling it takes the shared secret.
+const maxReplayEntries = 100000
+
+// replayCache remembers digests of opened frames until their timestamp
+// leaves the freshness window, after which the window rejects them anyway.
+type replayCache struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[[32]byte]time.Time
+}
+
+func newReplayCache(window time.Duration) *replayCache {
+	return &replayCache{window: window, seen: make(map[[32]byte]time.Time)}
+}
+
+// add records digest and reports whether it was new.
+func (c *replayCache) add(digest [32]byte, sent time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.seen[digest]; ok {
+		return false
+	}
+	if len(c.seen) >= maxReplayEntries {
+		for d, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, d)
+			}
+		}
+		if len(c.seen) >= maxReplayEntries {
+			return false
+		}
+	}
+	c.seen[digest] = sent.Add(c.window)
+	return true
+}
+
+// EnablePeerMode turns the proxy into the receiving end of an obfuscated
+// link: incoming requests are opened and reconstructed before being proxied,
+// and responses are sealed on the way back. The upstream side is no longer
+// obfuscated. Call it after the obfuscation defaults have been configured.
+func (p *Proxy) EnablePeerMode() {
+	if p.obfuscator == nil {
+		p.obfuscator = NewTrafficObfuscator()
+	}
+	p.peerObfuscator = p.obfuscator
+	p.obfuscator = nil
+	logger.Info("Obfuscation peer mode enabled (%s, %s)", p.peerObfuscator.method.Name(), p.peerObfuscator.profile.Name())
+}
+
+// peerResponseWriter buffers the proxied response so it can be sealed as a
+// whole.
+type peerResponseWriter struct {
+	mu     sync.Mutex
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *peerResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *peerResponseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *peerResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *peerResponseWriter) snapshot() (int, http.Header, []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	if status == 0 {
+		status = http.StatusGatewayTimeout
+	}
+	return status, w.header.Clone(), append([]byte(nil), w.body.Bytes()...)
+}
+
+func (p *Proxy) peerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		obfuscator := p.peerObfuscator
+
+		// Unlisted clients never get as far as the decryption code.
+		if p.clientACL != nil && !p.clientACL.PermitsAddr(r.RemoteAddr) {
+			logger.Warning("Rejected client %s by client ACL: %s %s", r.RemoteAddr, r.Method, r.URL.String())
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxPeerFrameSize)
+		}
+
+		inner, kind, err := obfuscator.ExtractRequest(r)
+		if err != nil {
+			// Look like any other web server to whoever is probing us.
+			logger.Warning("Rejected obfuscated request from %s: %v", r.RemoteAddr, err)
+			http.NotFound(w, r)
+			return
+		}
+		if kind == frameCover {
+			logger.Debug("Dropped cover request from %s", r.RemoteAddr)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		logger.Debug("Reconstructed obfuscated request: %s %s", inner.Method, inner.URL.String())
+
+		recorder := &peerResponseWriter{header: make(http.Header)}
+		next.ServeHTTP(recorder, inner)
+		status, header, data := recorder.snapshot()
+
+		responseKind := frameData
+		if kind == frameRequest {
+			res := &http.Response{
+				StatusCode:    status,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        header,
+				Body:          io.NopCloser(bytes.NewReader(data)),
+				ContentLength: int64(len(data)),
+			}
+			var buf bytes.Buffer
+			if err := res.Write(&buf); err != nil {
+				logger.RequestError(w, http.StatusInternalServerError, "Failed to encapsulate response", err)
+				return
+			}
+			data = buf.Bytes()
+			responseKind = frameResponse
+		}
+
+		body, contentType, err := obfuscator.sealFrame(responseKind, data, obfuscator.responseKey)
+		if err != nil {
+			logger.RequestError(w, http.StatusInternalServerError, "Failed to obfuscate response", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
@@ -1,11 +1,15 @@
 package proxy
 
 import (
+	"Groxy/acl"
 	"Groxy/logger"
 	"Groxy/tls"
 	"Groxy/auth"
 	"context"
+	cryptotls "crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -23,6 +27,10 @@ type Proxy struct {
 	timeout         time.Duration
 	obfuscator      *TrafficObfuscator
 	enableObfuscation bool
+	peerObfuscator  *TrafficObfuscator
+	targets         map[string]*Target
+	clientACL       *acl.ACL
+	destinationACL  *acl.ACL
 	AuthModule		*auth.AuthModule
 }
 
@@ -45,6 +53,81 @@ func NewProxy(targetURL *url.URL, tlsConfig *tls.Config, customHeader string, en
 	}
 }
 
+// SetObfuscationSecret derives the obfuscation keys from a secret shared
+// with the peer. Without it every run uses fresh random keys.
+func (p *Proxy) SetObfuscationSecret(secret string) error {
+	if p.obfuscator == nil || secret == "" {
+		return nil
+	}
+	obfuscator, err := p.obfuscator.WithSecret([]byte(secret))
+	if err != nil {
+		return err
+	}
+	p.obfuscator = obfuscator
+	return nil
+}
+
+// SetEncapsulation makes obfuscated requests carry the entire original
+// request and go to path instead. An empty path turns it off.
+func (p *Proxy) SetEncapsulation(path string) {
+	if p.obfuscator == nil {
+		return
+	}
+	p.obfuscator = p.obfuscator.WithEncapsulation(path)
+}
+
+// SetObfuscationMode selects the default obfuscation mode by name. Targets
+// can override it with their own obfuscation_mode.
+func (p *Proxy) SetObfuscationMode(name string) error {
+	if p.obfuscator == nil {
+		return nil
+	}
+	method, err := ObfuscationMethodByName(name)
+	if err != nil {
+		return err
+	}
+	p.obfuscator = p.obfuscator.WithMethod(method)
+	return nil
+}
+
+// SetMimicryProfile selects the default mimicry profile by name. Targets can
+// override it with their own mimicry setting.
+func (p *Proxy) SetMimicryProfile(name string) error {
+	if p.obfuscator == nil {
+		return nil
+	}
+	profile, err := MimicryProfileByName(name)
+	if err != nil {
+		return err
+	}
+	p.obfuscator = p.obfuscator.WithProfile(profile)
+	return nil
+}
+
+// SetTrafficShaping configures padding, delays and cover traffic for targets
+// without their own shaping settings.
+func (p *Proxy) SetTrafficShaping(config ShapingConfig) {
+	if p.obfuscator == nil || !config.enabled() {
+		return
+	}
+	p.obfuscator = p.obfuscator.WithShaper(NewTrafficShaper(config))
+	if config.CoverURL != "" {
+		p.startCoverTraffic(p.ctx, p.obfuscator)
+	}
+}
+
+// SetClientACL restricts which client addresses may use the proxy. It is
+// checked before authentication.
+func (p *Proxy) SetClientACL(clientACL *acl.ACL) {
+	p.clientACL = clientACL
+}
+
+// SetDestinationACL restricts which addresses transparent mode may connect
+// to. It is checked when dialing, after DNS resolution.
+func (p *Proxy) SetDestinationACL(destinationACL *acl.ACL) {
+	p.destinationACL = destinationACL
+}
+
 func (p *Proxy) SetTimeout(timeout time.Duration) {
 	p.timeout = timeout
 }
@@ -69,38 +152,126 @@ func (p *Proxy) StopWorkerPool() {
 	}
 }
 
-func (p *Proxy) createReverseProxy(targetURL *url.URL) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	
+func (p *Proxy) newTransport(targetURL *url.URL) *http.Transport {
+	dialer := p.dialer()
 	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
 		ResponseHeaderTimeout: p.timeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		IdleConnTimeout:       90 * time.Second,
 	}
 	
+	target := p.targetFor(targetURL)
 	if targetURL.Scheme == "https" {
-		transport.TLSClientConfig = p.tlsConfig.LoadClientConfig()
+		if target != nil && target.tlsConfig != nil {
+			transport.TLSClientConfig = target.tlsConfig.Clone()
+		} else {
+			transport.TLSClientConfig = p.tlsConfig.LoadClientConfig()
+		}
+	}
+	
+	if target != nil && target.fronted() {
+		applyFronting(transport, target, dialer)
 	}
 	
+	return transport
+}
+
+func (p *Proxy) createReverseProxy(targetURL *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	
+	obfuscator := p.obfuscatorFor(targetURL)
+	
+	var transport http.RoundTripper = p.newTransport(targetURL)
+	if obfuscator != nil && obfuscator.shaper != nil {
+		transport = &shapedTransport{base: transport, shaper: obfuscator.shaper}
+	}
 	proxy.Transport = transport
 	
-	ModifyRequest(proxy, p.customHeader, p.obfuscator)
-	ModifyResponse(proxy, p.obfuscator)
+	if target := p.targetFor(targetURL); target != nil && target.fronted() {
+		applyFrontingDirector(proxy, target, targetURL)
+	}
+	
+	proxy.ErrorHandler = p.upstreamErrorHandler(targetURL)
+	
+	ModifyRequest(proxy, p.customHeader, obfuscator)
+	ModifyResponse(proxy, obfuscator)
 	return proxy
 }
 
+// dialer returns the dialer for upstream connections. In transparent mode it
+// enforces the destination ACL on the resolved address.
+func (p *Proxy) dialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if p.targetURL == nil && p.destinationACL != nil {
+		dialer.Control = p.destinationACL.Control
+	}
+	return dialer
+}
+
+// upstreamErrorHandler reports failed upstream requests, naming the target
+// they were sent to.
+func (p *Proxy) upstreamErrorHandler(targetURL *url.URL) func(http.ResponseWriter, *http.Request, error) {
+	name := targetURL.Host
+	if target := p.targetFor(targetURL); target != nil {
+		name = target.Host
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, acl.ErrDenied) {
+			http.Error(w, "Destination not allowed", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, errObfuscationFailed) {
+			http.Error(w, "Request could not be obfuscated", http.StatusBadGateway)
+			return
+		}
+		if cert := tls.FailedCertificate(err); cert != nil {
+			logger.Error("Upstream TLS verification failed for target %s: certificate %q issued by %q (SPKI %s, expires %s): %v",
+				name, cert.Subject.String(), cert.Issuer.String(), tls.SPKIFingerprint(cert), cert.NotAfter.Format(time.RFC3339), err)
+			http.Error(w, "Upstream certificate verification failed", http.StatusBadGateway)
+			return
+		}
+		if isTLSError(err) {
+			logger.Error("TLS handshake with target %s failed: %v", name, err)
+			http.Error(w, "Upstream TLS handshake failed", http.StatusBadGateway)
+			return
+		}
+		logger.Error("Proxy error for %s: %v", r.URL.String(), err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// isTLSError reports handshake failures that are not certificate
+// verification errors, such as alerts sent by an upstream that rejected our
+// client certificate.
+func isTLSError(err error) bool {
+	var recordErr cryptotls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error")
+}
+
 func (p *Proxy) SetAuthModule(AuthModule *auth.AuthModule) {
 	p.AuthModule = AuthModule
 }
 
 func (p *Proxy) Handler() http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         
-        if p.AuthModule != nil && !p.AuthModule.Authenticate(r) {
-            w.WriteHeader(http.StatusUnauthorized)
-            w.Write([]byte("Unauthorized"))
+        if p.clientACL != nil && !p.clientACL.PermitsAddr(r.RemoteAddr) {
+            logger.Warning("Rejected client %s by client ACL: %s %s", r.RemoteAddr, r.Method, r.URL.String())
+            http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
             return
         }
+        if p.AuthModule != nil {
+            var ok bool
+            if r, ok = p.AuthModule.Authorize(w, r); !ok {
+                return
+            }
+        }
         ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
         defer cancel()
         
@@ -129,6 +300,11 @@ func (p *Proxy) Handler() http.Handler {
             logger.LogRequestTimeout(r)
         }
     })
+
+    if p.peerObfuscator != nil {
+        return p.peerHandler(handler)
+    }
+    return handler
 }
 
 func (p *Proxy) handleTransparentProxy(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +334,9 @@ func (p *Proxy) handleTransparentProxy(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Proxying request to: %s", destinationHost)
 
 	scheme := "http"
-	if r.TLS != nil {
+	if r.URL.Scheme != "" {
+		scheme = r.URL.Scheme
+	} else if r.TLS != nil {
 		scheme = "https"
 	}
 
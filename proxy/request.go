@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httputil"
@@ -50,20 +53,57 @@ func ModifyRequest(proxy *httputil.ReverseProxy, customHeader string, obfuscator
 		}
 
 		if obfuscator != nil {
-			defer func() {
-				if r := recover(); r != nil {
-					logger.Error("Panic in obfuscation: %v", r)
-				}
-			}()
-
-			err := obfuscator.ApplyToRequest(req)
-			if err != nil {
-				logger.Error("Failed to apply obfuscation to request: %v", err)
+			if err := applyObfuscation(obfuscator, req); err != nil {
+				logger.Error("Failed to apply obfuscation to request, not forwarding it: %v", err)
+				// The request may be half rewritten; mark it so the transport
+				// refuses it instead of sending it in the clear.
+				*req = *req.WithContext(context.WithValue(req.Context(), obfuscationErrorKey{}, err))
+				return
 			}
 		}
 
 		logger.LogRequest(req)
 	}
+
+	if obfuscator != nil {
+		base := proxy.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		proxy.Transport = &obfuscationGuard{base: base}
+	}
+}
+
+// errObfuscationFailed is returned for requests that could not be
+// obfuscated, so the ErrorHandler answers them with 502.
+var errObfuscationFailed = errors.New("obfuscation failed")
+
+type obfuscationErrorKey struct{}
+
+// applyObfuscation obfuscates req, turning a panic into an error.
+func applyObfuscation(obfuscator *TrafficObfuscator, req *http.Request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in obfuscation: %v", r)
+		}
+	}()
+	return obfuscator.ApplyToRequest(req)
+}
+
+// obfuscationGuard refuses requests the Director failed to obfuscate so
+// nothing of them reaches the upstream.
+type obfuscationGuard struct {
+	base http.RoundTripper
+}
+
+func (g *obfuscationGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err, ok := req.Context().Value(obfuscationErrorKey{}).(error); ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %v", errObfuscationFailed, err)
+	}
+	return g.base.RoundTrip(req)
 }
 
 func getRandomUserAgent() string {
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// failingProfile is a mimicry profile whose Encode fails, or panics when
+// panics is set.
+type failingProfile struct {
+	panics bool
+}
+
+func (p failingProfile) Name() string { return "failing" }
+
+func (p failingProfile) Encode(payload []byte) ([]byte, string, error) {
+	if p.panics {
+		panic("encoder broke")
+	}
+	return nil, "", errors.New("encoder broke")
+}
+
+func (p failingProfile) Decode(body []byte, contentType string) ([]byte, error) {
+	return body, nil
+}
+
+func (p failingProfile) RequestHeaders() http.Header { return http.Header{} }
+
+func TestObfuscationFailureIsNotForwarded(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		profile       failingProfile
+		encapsulation string
+	}{
+		{"seal error", "GET", failingProfile{}, ""},
+		{"seal error with body", "POST", failingProfile{}, ""},
+		{"seal panic", "GET", failingProfile{panics: true}, ""},
+		{"encapsulated seal error", "GET", failingProfile{}, "/tunnel"},
+		{"encapsulated seal error with body", "POST", failingProfile{}, "/tunnel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+			}))
+			defer upstream.Close()
+
+			targetURL, err := url.Parse(upstream.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := NewProxy(targetURL, nil, "", true)
+			p.obfuscator = p.obfuscator.WithProfile(tt.profile).WithEncapsulation(tt.encapsulation)
+
+			var body io.Reader
+			if tt.method == "POST" {
+				body = strings.NewReader("secret body")
+			}
+			req := httptest.NewRequest(tt.method, "http://proxy.test/secret/path", body)
+			rec := httptest.NewRecorder()
+			p.createReverseProxy(targetURL).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+			}
+			if got := hits.Load(); got != 0 {
+				t.Errorf("upstream received %d requests, want none", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"Groxy/logger"
+)
+
+// ShapingConfig controls how the obfuscated link disguises payload sizes and
+// timing.
+type ShapingConfig struct {
+	// Buckets are the sizes payloads are padded up to. Payloads larger than
+	// the biggest bucket are padded to a multiple of it. Without buckets a
+	// random 100-500 bytes of jitter is added instead.
+	Buckets  []int
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// CoverURL receives dummy requests whenever the link has been idle for
+	// CoverInterval.
+	CoverURL      string
+	CoverInterval time.Duration
+}
+
+func (c ShapingConfig) enabled() bool {
+	return len(c.Buckets) > 0 || c.MinDelay > 0 || c.MaxDelay > 0 || c.CoverURL != ""
+}
+
+// validate rejects buckets that would break padding and negative delays.
+func (c ShapingConfig) validate() error {
+	for _, bucket := range c.Buckets {
+		if bucket <= 0 {
+			return fmt.Errorf("pad bucket %d is not a positive size", bucket)
+		}
+	}
+	if c.MinDelay < 0 || c.MaxDelay < 0 {
+		return fmt.Errorf("shaping delays must not be negative")
+	}
+	return nil
+}
+
+type TrafficShaper struct {
+	config     ShapingConfig
+	lastActive atomic.Int64
+}
+
+func NewTrafficShaper(config ShapingConfig) *TrafficShaper {
+	buckets := append([]int(nil), config.Buckets...)
+	sort.Ints(buckets)
+	config.Buckets = buckets
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = config.MinDelay
+	}
+
+	shaper := &TrafficShaper{config: config}
+	shaper.markActive()
+	return shaper
+}
+
+func (s *TrafficShaper) Config() ShapingConfig {
+	return s.config
+}
+
+func (s *TrafficShaper) markActive() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *TrafficShaper) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// paddedSize returns the bucket a framed payload of size bytes is padded to.
+func (s *TrafficShaper) paddedSize(size int) int {
+	for _, bucket := range s.config.Buckets {
+		if size <= bucket {
+			return bucket
+		}
+	}
+	largest := s.config.Buckets[len(s.config.Buckets)-1]
+	return (size + largest - 1) / largest * largest
+}
+
+func (s *TrafficShaper) randomDelay() time.Duration {
+	spread := s.config.MaxDelay - s.config.MinDelay
+	if spread <= 0 {
+		return s.config.MinDelay
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(spread)))
+	if err != nil {
+		return s.config.MinDelay
+	}
+	return s.config.MinDelay + time.Duration(n.Int64())
+}
+
+// wait sleeps for a random delay, returning early if ctx ends.
+func (s *TrafficShaper) wait(ctx context.Context) error {
+	delay := s.randomDelay()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shapedTransport delays every upstream request and records activity so
+// cover traffic is only sent while the link is idle.
+type shapedTransport struct {
+	base   http.RoundTripper
+	shaper *TrafficShaper
+}
+
+func (t *shapedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.shaper.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	t.shaper.markActive()
+	return t.base.RoundTrip(req)
+}
+
+// startCoverTraffic sends a dummy request to the shaper's cover URL each time
+// the link has been idle for the cover interval, until ctx is cancelled.
+func (p *Proxy) startCoverTraffic(ctx context.Context, obfuscator *TrafficObfuscator) {
+	shaper := obfuscator.shaper
+	coverURL, err := url.Parse(shaper.config.CoverURL)
+	if err != nil || coverURL.Scheme == "" || coverURL.Host == "" {
+		logger.Error("Invalid cover traffic URL: %s", shaper.config.CoverURL)
+		return
+	}
+
+	interval := shaper.config.CoverInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	client := &http.Client{
+		Transport: p.newTransport(coverURL),
+		Timeout:   p.timeout,
+	}
+
+	logger.Info("Sending cover traffic to %s after %v of idle time", coverURL.Host, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if shaper.idleFor() < interval {
+					continue
+				}
+				if err := p.sendCoverRequest(ctx, client, coverURL, obfuscator); err != nil {
+					logger.Debug("Cover request to %s failed: %v", coverURL.Host, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Proxy) sendCoverRequest(ctx context.Context, client *http.Client, coverURL *url.URL, obfuscator *TrafficObfuscator) error {
+	filler := make([]byte, obfuscator.randomJitterSize(200, 2000))
+	rand.Read(filler)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, coverURL.String(), bytes.NewReader(filler))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", getRandomUserAgent())
+
+	if err := obfuscator.applyFrame(req, frameCover); err != nil {
+		return err
+	}
+
+	obfuscator.shaper.markActive()
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	logger.Debug("Sent cover request to %s: %s", coverURL.Host, res.Status)
+	return nil
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShapingConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ShapingConfig
+		wantErr bool
+	}{
+		{"empty", ShapingConfig{}, false},
+		{"buckets", ShapingConfig{Buckets: []int{1024, 4096}}, false},
+		{"zero bucket", ShapingConfig{Buckets: []int{1024, 0}}, true},
+		{"negative bucket", ShapingConfig{Buckets: []int{-1}}, true},
+		{"delays", ShapingConfig{MinDelay: time.Millisecond, MaxDelay: time.Second}, false},
+		{"negative delay", ShapingConfig{MinDelay: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShapingConfigEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ShapingConfig
+		want   bool
+	}{
+		{"empty", ShapingConfig{}, false},
+		{"buckets", ShapingConfig{Buckets: []int{1024}}, true},
+		{"min delay only", ShapingConfig{MinDelay: time.Millisecond}, true},
+		{"max delay only", ShapingConfig{MaxDelay: time.Millisecond}, true},
+		{"cover traffic", ShapingConfig{CoverURL: "https://peer.example.com/"}, true},
+		{"cover interval alone", ShapingConfig{CoverInterval: time.Minute}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.enabled(); got != tt.want {
+				t.Errorf("enabled() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaddedSize(t *testing.T) {
+	shaper := NewTrafficShaper(ShapingConfig{Buckets: []int{4096, 1024}})
+
+	tests := []struct {
+		size int
+		want int
+	}{
+		{1, 1024},
+		{1024, 1024},
+		{1025, 4096},
+		{4096, 4096},
+		{4097, 8192},
+		{12289, 16384},
+	}
+
+	for _, tt := range tests {
+		if got := shaper.paddedSize(tt.size); got != tt.want {
+			t.Errorf("paddedSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTargetsRejectsBadBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"valid", `[{"host": "a.example.com", "pad_buckets": [512, 2048]}]`, ""},
+		{"zero bucket", `[{"host": "a.example.com", "pad_buckets": [0]}]`, "not a positive size"},
+		{"negative bucket", `[{"host": "a.example.com", "pad_buckets": [1024, -5]}]`, "not a positive size"},
+		{"negative delay", `[{"host": "a.example.com", "min_delay": "-1s"}]`, "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadTargets(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("LoadTargets() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadTargets() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	cryptotls "crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"Groxy/logger"
+	"Groxy/tls"
+)
+
+// Target holds the settings for one upstream host. Hosts without an entry
+// use the proxy-wide defaults from the command line.
+type Target struct {
+	Host            string `json:"host"`
+	ObfuscationMode string `json:"obfuscation_mode,omitempty"`
+	Mimicry         string `json:"mimicry,omitempty"`
+	// Encapsulate overrides -encapsulate; EncapsulationPath overrides the
+	// path encapsulated requests are sent to.
+	Encapsulate       *bool  `json:"encapsulate,omitempty"`
+	EncapsulationPath string `json:"encapsulation_path,omitempty"`
+
+	// Domain fronting. DialAddress is the host:port actually connected to,
+	// ServerName the TLS SNI and HostHeader the HTTP Host header. Each
+	// defaults to the target URL when unset.
+	DialAddress string `json:"dial_address,omitempty"`
+	ServerName  string `json:"server_name,omitempty"`
+	HostHeader  string `json:"host_header,omitempty"`
+
+	// Traffic shaping. Setting any of these replaces the command-line
+	// shaping defaults for this target.
+	PadBuckets    []int    `json:"pad_buckets,omitempty"`
+	MinDelay      Duration `json:"min_delay,omitempty"`
+	MaxDelay      Duration `json:"max_delay,omitempty"`
+	CoverURL      string   `json:"cover_url,omitempty"`
+	CoverInterval Duration `json:"cover_interval,omitempty"`
+
+	// Upstream TLS verification. CAFile replaces the system roots, SPKIPins
+	// require a matching key in the chain and InsecureSkipVerify turns off
+	// chain and hostname checks.
+	CAFile             string   `json:"ca_file,omitempty"`
+	SPKIPins           []string `json:"spki_pins,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"`
+
+	// Client certificate presented to upstreams that require mutual TLS.
+	// The key may be encrypted, with its password read from
+	// ClientKeyPasswordFile.
+	ClientCert            string `json:"client_cert,omitempty"`
+	ClientKey             string `json:"client_key,omitempty"`
+	ClientKeyPasswordFile string `json:"client_key_password_file,omitempty"`
+
+	obfuscator *TrafficObfuscator
+	tlsConfig  *cryptotls.Config
+	clientCert *tls.ClientCertManager
+}
+
+// Duration is a time.Duration written as a string such as "250ms" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"250ms\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (t *Target) shapingConfig() ShapingConfig {
+	return ShapingConfig{
+		Buckets:       t.PadBuckets,
+		MinDelay:      time.Duration(t.MinDelay),
+		MaxDelay:      time.Duration(t.MaxDelay),
+		CoverURL:      t.CoverURL,
+		CoverInterval: time.Duration(t.CoverInterval),
+	}
+}
+
+// LoadTargets reads a JSON array of targets from path.
+func LoadTargets(path string) ([]*Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets file: %v", err)
+	}
+
+	var targets []*Target
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("failed to parse targets file: %v", err)
+	}
+
+	for i, target := range targets {
+		if target == nil || target.Host == "" {
+			return nil, fmt.Errorf("target %d has no host", i)
+		}
+		if err := target.shapingConfig().validate(); err != nil {
+			return nil, fmt.Errorf("target %s: %v", target.Host, err)
+		}
+	}
+	return targets, nil
+}
+
+func (p *Proxy) SetTargets(targets []*Target) error {
+	byHost := make(map[string]*Target, len(targets))
+	for _, target := range targets {
+		if err := p.prepareTarget(target); err != nil {
+			return fmt.Errorf("target %s: %v", target.Host, err)
+		}
+		byHost[strings.ToLower(target.Host)] = target
+		if target.fronted() {
+			logger.Info("Target %s uses domain fronting (dial %q, SNI %q, Host %q)", target.Host, target.DialAddress, target.ServerName, target.HostHeader)
+		}
+		if target.InsecureSkipVerify {
+			logger.Warning("Target %s: upstream TLS certificate verification is DISABLED", target.Host)
+			fmt.Printf("⚠️ WARNING: TLS certificate verification is disabled for target %s\n", target.Host)
+		}
+	}
+	p.targets = byHost
+	return nil
+}
+
+// prepareTarget derives the target's obfuscator from the proxy defaults, so
+// those must be configured before SetTargets is called.
+func (p *Proxy) prepareTarget(target *Target) error {
+	if err := p.loadTargetClientCert(target); err != nil {
+		return err
+	}
+	tlsConfig, err := p.tlsConfig.LoadUpstreamConfig(tls.UpstreamOptions{
+		RootCAFile: target.CAFile,
+		SPKIPins:   target.SPKIPins,
+		Insecure:   target.InsecureSkipVerify,
+		ClientCert: target.clientCert,
+	})
+	if err != nil {
+		return err
+	}
+	target.tlsConfig = tlsConfig
+
+	if p.obfuscator == nil {
+		return nil
+	}
+
+	obfuscator := p.obfuscator
+	if target.ObfuscationMode != "" {
+		method, err := ObfuscationMethodByName(target.ObfuscationMode)
+		if err != nil {
+			return err
+		}
+		obfuscator = obfuscator.WithMethod(method)
+	}
+	if target.Mimicry != "" {
+		profile, err := MimicryProfileByName(target.Mimicry)
+		if err != nil {
+			return err
+		}
+		obfuscator = obfuscator.WithProfile(profile)
+	}
+	if target.Encapsulate != nil && !*target.Encapsulate {
+		obfuscator = obfuscator.WithEncapsulation("")
+	} else if target.Encapsulate != nil || target.EncapsulationPath != "" {
+		path := target.EncapsulationPath
+		if path == "" {
+			path = obfuscator.encapsulationPath
+		}
+		if path == "" {
+			path = DefaultEncapsulationPath
+		}
+		obfuscator = obfuscator.WithEncapsulation(path)
+	}
+	shaping := target.shapingConfig()
+	if err := shaping.validate(); err != nil {
+		return err
+	}
+	if shaping.enabled() {
+		obfuscator = obfuscator.WithShaper(NewTrafficShaper(shaping))
+		if shaping.CoverURL != "" {
+			p.startCoverTraffic(p.ctx, obfuscator)
+		}
+	}
+	target.obfuscator = obfuscator
+	return nil
+}
+
+func (p *Proxy) loadTargetClientCert(target *Target) error {
+	if target.ClientCert == "" && target.ClientKey == "" {
+		return nil
+	}
+	if target.ClientCert == "" || target.ClientKey == "" {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+
+	var password []byte
+	if target.ClientKeyPasswordFile != "" {
+		data, err := os.ReadFile(target.ClientKeyPasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client key password: %v", err)
+		}
+		password = []byte(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	manager, err := tls.NewClientCertManager(target.ClientCert, target.ClientKey, password)
+	if err != nil {
+		return err
+	}
+	manager.OnReload = func(cert *cryptotls.Certificate) {
+		logger.Info("Reloaded client certificate for target %s: %s (expires %s)", target.Host, cert.Leaf.Subject.String(), cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	manager.OnError = func(err error) {
+		logger.Error("Target %s: %v", target.Host, err)
+	}
+	manager.StartWatching(10 * time.Second)
+
+	leaf := manager.Certificate().Leaf
+	logger.Info("Target %s presents client certificate %s (expires %s)", target.Host, leaf.Subject.String(), leaf.NotAfter.Format(time.RFC3339))
+	target.clientCert = manager
+	return nil
+}
+
+// ClientCertificates returns the client certificate managers of the targets
+// that present one, keyed by target host.
+func (p *Proxy) ClientCertificates() map[string]*tls.ClientCertManager {
+	certs := make(map[string]*tls.ClientCertManager)
+	for host, target := range p.targets {
+		if target.clientCert != nil {
+			certs[host] = target.clientCert
+		}
+	}
+	return certs
+}
+
+// targetFor looks up a target by host:port first and then by bare hostname.
+func (p *Proxy) targetFor(u *url.URL) *Target {
+	if target, ok := p.targets[strings.ToLower(u.Host)]; ok {
+		return target
+	}
+	if target, ok := p.targets[strings.ToLower(u.Hostname())]; ok {
+		return target
+	}
+	return nil
+}
+
+func (p *Proxy) obfuscatorFor(u *url.URL) *TrafficObfuscator {
+	if target := p.targetFor(u); target != nil && target.obfuscator != nil {
+		return target.obfuscator
+	}
+	return p.obfuscator
+}
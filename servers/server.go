@@ -10,6 +10,8 @@ import (
     "strings"
     "sync"
     "net"
+    "slices"
+    cryptotls "crypto/tls"
 )
 
 type Server struct {
@@ -20,9 +22,12 @@ type Server struct {
     httpPort    string
     httpsPort   string
     enableRedirection bool
+    rotationInterval  time.Duration
+    acme        *tls.ACME
     wg          sync.WaitGroup
     httpServer  *http.Server
     httpsServer *http.Server
+    statusServer *http.Server
     ctx         context.Context
     cancel      context.CancelFunc
 }
@@ -37,6 +42,7 @@ func NewServer(handler http.Handler, tlsManager *tls.Manager, certFile, keyFile
         httpPort:   httpPort,
         httpsPort:  httpsPort,
         enableRedirection: false,
+        rotationInterval:  0,
         ctx:        ctx,
         cancel:     cancel,
     }
@@ -46,6 +52,19 @@ func (s *Server) SetRedirection(enable bool) {
     s.enableRedirection = enable
 }
 
+// SetRotation sets how often a new server certificate is generated; zero
+// disables generation so that certificates managed elsewhere are only
+// reloaded when they change.
+func (s *Server) SetRotation(interval time.Duration) {
+    s.rotationInterval = interval
+}
+
+// SetACME makes the HTTP listener answer ACME HTTP-01 challenges before
+// redirecting or proxying.
+func (s *Server) SetACME(acme *tls.ACME) {
+    s.acme = acme
+}
+
 func (s *Server) StartHTTP() error {
     addr := ":" + s.httpPort
     
@@ -67,6 +86,9 @@ func (s *Server) StartHTTP() error {
     } else {
         serverHandler = s.handler
     }
+    if s.acme != nil {
+        serverHandler = s.acme.HTTPHandler(serverHandler)
+    }
     
     s.httpServer = &http.Server{
         Addr:         addr,
@@ -96,7 +118,10 @@ func (s *Server) StartHTTPS() error {
         return fmt.Errorf("failed to load TLS config: %v", err)
     }
 
-    s.tlsManager.StartRotation(30 * 24 * time.Hour)
+    if s.rotationInterval > 0 {
+        s.tlsManager.StartRotation(s.rotationInterval)
+    }
+    s.tlsManager.StartWatching(10 * time.Second)
     
     addr := ":" + s.httpsPort
     s.httpsServer = &http.Server{
@@ -108,13 +133,27 @@ func (s *Server) StartHTTPS() error {
         IdleTimeout:  120 * time.Second,
         BaseContext:  func(_ net.Listener) context.Context { return s.ctx },
     }
+    if !slices.Contains(tlsConfig.NextProtos, "h2") {
+        // net/http would otherwise add h2 back to the policy's ALPN list.
+        s.httpsServer.TLSNextProto = map[string]func(*http.Server, *cryptotls.Conn, http.Handler){}
+    }
+
+    // Serving on our own TLS listener, rather than ListenAndServeTLS which
+    // clones the config, keeps session ticket key rotation effective.
+    if err := s.tlsManager.StartTicketRotation(tlsConfig, s.tlsManager.TicketKeyRotation()); err != nil {
+        return err
+    }
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
     
     s.wg.Add(1)
     go func() {
         defer s.wg.Done()
         logger.LogHTTPSServerStart(s.httpsPort)
         
-        if err := s.httpsServer.ListenAndServeTLS(s.certFile, s.keyFile); err != nil && err != http.ErrServerClosed {
+        if err := s.httpsServer.Serve(cryptotls.NewListener(listener, tlsConfig)); err != nil && err != http.ErrServerClosed {
             logger.LogServerError(err)
         }
     }()
@@ -122,6 +161,38 @@ func (s *Server) StartHTTPS() error {
     return nil
 }
 
+// StartStatus serves the status API on addr, separately from the proxy
+// listeners so it is never forwarded upstream.
+func (s *Server) StartStatus(addr string) error {
+    mux := http.NewServeMux()
+    mux.Handle("/status/certificates", s.tlsManager.StatusHandler())
+
+    s.statusServer = &http.Server{
+        Addr:         addr,
+        Handler:      mux,
+        ReadTimeout:  10 * time.Second,
+        WriteTimeout: 10 * time.Second,
+        BaseContext:  func(_ net.Listener) context.Context { return s.ctx },
+    }
+
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+
+    s.wg.Add(1)
+    go func() {
+        defer s.wg.Done()
+        logger.Info("Status API listening on %s", addr)
+
+        if err := s.statusServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+            logger.LogServerError(err)
+        }
+    }()
+
+    return nil
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
     if ctx == nil {
         var cancel context.CancelFunc
@@ -147,7 +218,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
         logger.LogServerShutdownComplete("HTTPS")
     }
     
+    if s.statusServer != nil {
+        if err := s.statusServer.Shutdown(ctx); err != nil {
+            return err
+        }
+    }
+    
     s.tlsManager.StopRotation()
+    s.tlsManager.StopExpiryMonitor()
+    s.tlsManager.StopTicketRotation()
+    s.tlsManager.StopWatching()
     
     waitCh := make(chan struct{})
     go func() {
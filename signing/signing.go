@@ -0,0 +1,201 @@
+// Package signing signs HTTP requests for Groxy's hmac authentication method.
+//
+// A signed request carries a timestamp, a random nonce and the SHA-256 of its
+// body in headers, and an authorization header of the form
+//
+//	GROXY-HMAC-SHA256 keyId="job-1",signedHeaders="host;x-content-sha256;x-groxy-date;x-groxy-nonce",signature="<hex>"
+//
+// where the signature is HMAC-SHA256 over the canonical request: method,
+// escaped path, sorted query, the signed headers as name:value lines, the
+// signed header list and the body digest, joined by newlines.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm    = "GROXY-HMAC-SHA256"
+	DateHeader   = "X-Groxy-Date"
+	NonceHeader  = "X-Groxy-Nonce"
+	DigestHeader = "X-Content-Sha256"
+)
+
+// RequiredHeaders are signed on every request.
+var RequiredHeaders = []string{"host", "x-content-sha256", "x-groxy-date", "x-groxy-nonce"}
+
+// Signer signs requests with one client's key.
+type Signer struct {
+	KeyID  string
+	Secret []byte
+	// Headers lists extra headers to sign, e.g. "content-type".
+	Headers []string
+	// AuthHeader is where the signature goes. Use "Proxy-Authorization" when
+	// Groxy runs as a forward proxy. Defaults to "Authorization".
+	AuthHeader string
+}
+
+func NewSigner(keyID string, secret []byte) *Signer {
+	return &Signer{KeyID: keyID, Secret: secret}
+}
+
+// Sign adds the date, nonce, digest and signature headers to req. The body
+// is read and replaced so the request can still be sent.
+func (s *Signer) Sign(req *http.Request) error {
+	digest, err := BodyDigest(req, -1)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	req.Header.Set(DateHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(DigestHeader, digest)
+
+	signed := signedHeaderList(append(append([]string{}, RequiredHeaders...), s.Headers...))
+	signature := Compute(s.Secret, CanonicalRequest(req, signed, digest))
+
+	header := s.AuthHeader
+	if header == "" {
+		header = "Authorization"
+	}
+	req.Header.Set(header, fmt.Sprintf(`%s keyId="%s",signedHeaders="%s",signature="%s"`,
+		Algorithm, s.KeyID, strings.Join(signed, ";"), signature))
+	return nil
+}
+
+// Signature is a parsed authorization header.
+type Signature struct {
+	KeyID         string
+	SignedHeaders []string
+	Signature     string
+}
+
+func Parse(value string) (*Signature, error) {
+	prefix := Algorithm + " "
+	if !strings.HasPrefix(value, prefix) {
+		return nil, fmt.Errorf("not a %s signature", Algorithm)
+	}
+
+	sig := &Signature{}
+	for _, field := range strings.Split(value[len(prefix):], ",") {
+		name, quoted, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed signature parameter %q", field)
+		}
+		param, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("malformed signature parameter %q", field)
+		}
+		switch name {
+		case "keyId":
+			sig.KeyID = param
+		case "signedHeaders":
+			sig.SignedHeaders = strings.Split(param, ";")
+		case "signature":
+			sig.Signature = param
+		}
+	}
+
+	if sig.KeyID == "" || sig.Signature == "" || len(sig.SignedHeaders) == 0 {
+		return nil, fmt.Errorf("signature is missing keyId, signedHeaders or signature")
+	}
+	return sig, nil
+}
+
+// CanonicalRequest builds the string that is signed.
+func CanonicalRequest(req *http.Request, signedHeaders []string, digest string) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.URL.EscapedPath() + "\n")
+	b.WriteString(req.URL.Query().Encode() + "\n")
+	for _, name := range signedHeaders {
+		b.WriteString(name + ":" + headerValue(req, name) + "\n")
+	}
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(digest)
+	return b.String()
+}
+
+// Compute returns the hex HMAC-SHA256 of canonical.
+func Compute(secret []byte, canonical string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BodyDigest returns the hex SHA-256 of req's body and replaces the body so
+// it can be read again. Bodies larger than limit bytes are rejected; a
+// negative limit reads any size. On error the body is put back as well, with
+// what was already read in front of the rest, so the request stays intact.
+func BodyDigest(req *http.Request, limit int64) (string, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		original := req.Body
+		reader := io.Reader(original)
+		if limit >= 0 {
+			reader = io.LimitReader(original, limit+1)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil || (limit >= 0 && int64(len(data)) > limit) {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(data), original), original}
+			if err != nil {
+				return "", fmt.Errorf("failed to read body: %v", err)
+			}
+			return "", fmt.Errorf("body exceeds %d bytes", limit)
+		}
+		original.Close()
+		body = data
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func headerValue(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	// Values returns the header's own slice, so trim into a copy.
+	var values []string
+	for _, value := range req.Header.Values(name) {
+		values = append(values, strings.TrimSpace(value))
+	}
+	return strings.Join(values, ",")
+}
+
+func signedHeaderList(headers []string) []string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, header := range headers {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && !seen[header] {
+			seen[header] = true
+			list = append(list, header)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,194 @@
+package signing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers [][2]string
+		signed  []string
+		want    string
+	}{
+		{
+			name:   "sorted query",
+			method: "GET",
+			target: "http://api.example.com/v1/items?b=2&a=1&a=0",
+			signed: []string{"host"},
+			want:   "GET\n/v1/items\na=1&a=0&b=2\nhost:api.example.com\nhost\nDIGEST",
+		},
+		{
+			name:   "escaped path",
+			method: "POST",
+			target: "http://api.example.com/a%20b/c",
+			signed: []string{"host"},
+			want:   "POST\n/a%20b/c\n\nhost:api.example.com\nhost\nDIGEST",
+		},
+		{
+			name:    "trimmed and joined values",
+			method:  "PUT",
+			target:  "http://api.example.com/",
+			headers: [][2]string{{"X-Tag", "  one "}, {"X-Tag", "two  "}},
+			signed:  []string{"host", "x-tag"},
+			want:    "PUT\n/\n\nhost:api.example.com\nx-tag:one,two\nhost;x-tag\nDIGEST",
+		},
+		{
+			name:   "missing header",
+			method: "GET",
+			target: "http://api.example.com/",
+			signed: []string{"x-missing"},
+			want:   "GET\n/\n\nx-missing:\nx-missing\nDIGEST",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for _, header := range tt.headers {
+				req.Header.Add(header[0], header[1])
+			}
+			if got := CanonicalRequest(req, tt.signed, "DIGEST"); got != tt.want {
+				t.Errorf("CanonicalRequest() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderValueLeavesHeaderUntouched(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	req.Header.Add("X-Tag", " padded ")
+
+	if got := headerValue(req, "x-tag"); got != "padded" {
+		t.Errorf("headerValue() = %q, want %q", got, "padded")
+	}
+	if got := req.Header.Get("X-Tag"); got != " padded " {
+		t.Errorf("header changed to %q", got)
+	}
+}
+
+func TestSignedHeaderList(t *testing.T) {
+	got := signedHeaderList([]string{"X-Groxy-Date", "host", " Content-Type ", "HOST", ""})
+	want := "content-type;host;x-groxy-date"
+	if strings.Join(got, ";") != want {
+		t.Errorf("signedHeaderList() = %v, want %s", got, want)
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestBodyDigest(t *testing.T) {
+	const emptyDigest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	readErr := errors.New("connection reset")
+
+	tests := []struct {
+		name       string
+		body       io.Reader
+		limit      int64
+		wantDigest string
+		wantErr    string
+		// wantBody is what must still be readable from req.Body afterwards.
+		wantBody string
+	}{
+		{"no body", nil, 10, emptyDigest, "", ""},
+		{"empty body", strings.NewReader(""), 10, emptyDigest, "", ""},
+		{"within limit", strings.NewReader("hello"), 10, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "", "hello"},
+		{"at limit", strings.NewReader("hello"), 5, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "", "hello"},
+		{"no limit", strings.NewReader("hello"), -1, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "", "hello"},
+		{"over limit", strings.NewReader("hello world"), 5, "", "exceeds 5 bytes", "hello world"},
+		{"read error", &failingReader{data: []byte("partial"), err: readErr}, 100, "", "connection reset", "partial"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://api.example.com/", tt.body)
+			digest, err := BodyDigest(req, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("BodyDigest() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil || digest != tt.wantDigest {
+				t.Fatalf("BodyDigest() = %q, %v; want %q", digest, err, tt.wantDigest)
+			}
+
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("body afterwards = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	signer := NewSigner("job-1", []byte("secret"))
+	signer.Headers = []string{"Content-Type"}
+
+	req, err := http.NewRequest("POST", "http://api.example.com/v1/items?x=1", bytes.NewReader([]byte(`{"a":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := Parse(req.Header.Get("Authorization"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if sig.KeyID != "job-1" || strings.Join(sig.SignedHeaders, ";") != "content-type;host;x-content-sha256;x-groxy-date;x-groxy-nonce" {
+		t.Errorf("Parse() = %+v", sig)
+	}
+
+	digest, err := BodyDigest(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != req.Header.Get(DigestHeader) {
+		t.Errorf("digest header %s, body digest %s", req.Header.Get(DigestHeader), digest)
+	}
+	if expected := Compute([]byte("secret"), CanonicalRequest(req, sig.SignedHeaders, digest)); expected != sig.Signature {
+		t.Errorf("signature %s does not verify, want %s", sig.Signature, expected)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"valid", `GROXY-HMAC-SHA256 keyId="a",signedHeaders="host",signature="00"`, false},
+		{"spaces", `GROXY-HMAC-SHA256 keyId="a", signedHeaders="host", signature="00"`, false},
+		{"wrong scheme", `Bearer abc`, true},
+		{"missing signature", `GROXY-HMAC-SHA256 keyId="a",signedHeaders="host"`, true},
+		{"unquoted", `GROXY-HMAC-SHA256 keyId=a,signedHeaders="host",signature="00"`, true},
+		{"no equals", `GROXY-HMAC-SHA256 keyId`, true},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Parse() error = %v, wantErr %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package tls
+
+import (
+    "context"
+    "crypto/x509"
+    cryptotls "crypto/tls"
+    "encoding/pem"
+    "fmt"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig describes how certificates are obtained from an ACME CA such
+// as Let's Encrypt or a local Pebble server.
+type ACMEConfig struct {
+    Domains []string
+    Email   string
+    // DirectoryURL defaults to Let's Encrypt production.
+    DirectoryURL string
+    // RootCAFile is trusted when talking to the directory, for test CAs
+    // like Pebble whose API uses a private certificate.
+    RootCAFile string
+    // CacheDir holds the account key and issued certificates.
+    CacheDir string
+    // RenewBefore is how long before expiry certificates are renewed.
+    RenewBefore time.Duration
+}
+
+// ACME obtains and renews certificates for its domains. HTTP-01 challenges
+// are answered by HTTPHandler on the HTTP listener and TLS-ALPN-01
+// challenges by GetCertificate on the HTTPS listener.
+type ACME struct {
+    manager *autocert.Manager
+    domains []string
+}
+
+func NewACME(config ACMEConfig) (*ACME, error) {
+    if len(config.Domains) == 0 {
+        return nil, fmt.Errorf("ACME requires at least one domain")
+    }
+    if config.CacheDir == "" {
+        config.CacheDir = "certs/acme"
+    }
+
+    client := &acme.Client{DirectoryURL: config.DirectoryURL}
+    if client.DirectoryURL == "" {
+        client.DirectoryURL = acme.LetsEncryptURL
+    }
+    if config.RootCAFile != "" {
+        pool, err := loadCertPool(config.RootCAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load ACME root CA: %v", err)
+        }
+        client.HTTPClient = &http.Client{
+            Timeout: 30 * time.Second,
+            Transport: &http.Transport{
+                TLSClientConfig: &cryptotls.Config{RootCAs: pool, MinVersion: cryptotls.VersionTLS12},
+            },
+        }
+    }
+
+    domains := make([]string, len(config.Domains))
+    for i, domain := range config.Domains {
+        domains[i] = strings.ToLower(domain)
+    }
+
+    return &ACME{
+        manager: &autocert.Manager{
+            Prompt:      autocert.AcceptTOS,
+            Cache:       autocert.DirCache(config.CacheDir),
+            HostPolicy:  autocert.HostWhitelist(domains...),
+            RenewBefore: config.RenewBefore,
+            Email:       config.Email,
+            Client:      client,
+        },
+        domains: domains,
+    }, nil
+}
+
+// Handles reports whether serverName is one of the ACME domains.
+func (a *ACME) Handles(serverName string) bool {
+    return slices.Contains(a.domains, strings.ToLower(strings.TrimSuffix(serverName, ".")))
+}
+
+// IsChallenge reports whether hello is a TLS-ALPN-01 validation request.
+func IsChallenge(hello *cryptotls.ClientHelloInfo) bool {
+    return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// GetCertificate returns the certificate for hello, obtaining it on first
+// use. It also answers TLS-ALPN-01 challenges.
+func (a *ACME) GetCertificate(hello *cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error) {
+    cert, err := a.manager.GetCertificate(hello)
+    if err != nil {
+        return nil, fmt.Errorf("ACME certificate for %s: %v", hello.ServerName, err)
+    }
+    if cert.Leaf == nil && len(cert.Certificate) > 0 {
+        cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+    }
+    return cert, nil
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to
+// fallback.
+func (a *ACME) HTTPHandler(fallback http.Handler) http.Handler {
+    return a.manager.HTTPHandler(fallback)
+}
+
+// Certificates returns the issued certificates found in the cache, keyed by
+// cache entry: the domain for ECDSA certificates and domain+"+rsa" for the
+// RSA ones served to older clients. Domains not issued yet are left out.
+func (a *ACME) Certificates() map[string]*cryptotls.Certificate {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    certs := make(map[string]*cryptotls.Certificate)
+    for _, domain := range a.domains {
+        for _, key := range []string{domain, domain + "+rsa"} {
+            data, err := a.manager.Cache.Get(ctx, key)
+            if err != nil {
+                continue
+            }
+            if cert := parseCachedCertificate(data); cert != nil {
+                certs[key] = cert
+            }
+        }
+    }
+    return certs
+}
+
+// parseCachedCertificate reads the chain from an autocert cache entry, which
+// holds the private key followed by the certificates. The key is skipped.
+func parseCachedCertificate(data []byte) *cryptotls.Certificate {
+    cert := &cryptotls.Certificate{}
+    for {
+        var block *pem.Block
+        block, data = pem.Decode(data)
+        if block == nil {
+            break
+        }
+        if block.Type == "CERTIFICATE" {
+            cert.Certificate = append(cert.Certificate, block.Bytes)
+        }
+    }
+    if len(cert.Certificate) == 0 {
+        return nil
+    }
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return nil
+    }
+    cert.Leaf = leaf
+    return cert
+}
@@ -0,0 +1,94 @@
+package tls
+
+import (
+    cryptotls "crypto/tls"
+    "os"
+    "testing"
+
+    "golang.org/x/crypto/acme"
+)
+
+func TestACMEHandles(t *testing.T) {
+    a, err := NewACME(ACMEConfig{Domains: []string{"Proxy.Example.com"}, CacheDir: t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        serverName string
+        want       bool
+    }{
+        {"proxy.example.com", true},
+        {"PROXY.example.com", true},
+        {"proxy.example.com.", true},
+        {"other.example.com", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        if got := a.Handles(tt.serverName); got != tt.want {
+            t.Errorf("Handles(%q) = %v, want %v", tt.serverName, got, tt.want)
+        }
+    }
+}
+
+func TestIsChallenge(t *testing.T) {
+    tests := []struct {
+        name   string
+        protos []string
+        want   bool
+    }{
+        {"acme only", []string{acme.ALPNProto}, true},
+        {"acme among others", []string{"h2", acme.ALPNProto}, false},
+        {"regular client", []string{"h2", "http/1.1"}, false},
+        {"no alpn", nil, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := IsChallenge(&cryptotls.ClientHelloInfo{SupportedProtos: tt.protos}); got != tt.want {
+                t.Errorf("IsChallenge(%v) = %v, want %v", tt.protos, got, tt.want)
+            }
+        })
+    }
+}
+
+// TestACMEPebble obtains a certificate from a running Pebble server. Start
+// Pebble with PEBBLE_VA_ALWAYS_VALID=1 so it doesn't need to reach this
+// process, then run
+//
+//    GROXY_PEBBLE_DIRECTORY=https://localhost:14000/dir GROXY_PEBBLE_CA=pebble.minica.pem go test ./tls -run Pebble
+func TestACMEPebble(t *testing.T) {
+    directory := os.Getenv("GROXY_PEBBLE_DIRECTORY")
+    if directory == "" {
+        t.Skip("GROXY_PEBBLE_DIRECTORY not set")
+    }
+
+    cacheDir := t.TempDir()
+    a, err := NewACME(ACMEConfig{
+        Domains:      []string{"groxy.test"},
+        Email:        "admin@groxy.test",
+        DirectoryURL: directory,
+        RootCAFile:   os.Getenv("GROXY_PEBBLE_CA"),
+        CacheDir:     cacheDir,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cert, err := a.GetCertificate(&cryptotls.ClientHelloInfo{
+        ServerName:       "groxy.test",
+        SignatureSchemes: []cryptotls.SignatureScheme{cryptotls.ECDSAWithP256AndSHA256},
+        SupportedCurves:  []cryptotls.CurveID{cryptotls.CurveP256},
+        CipherSuites:     []uint16{cryptotls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := cert.Leaf.VerifyHostname("groxy.test"); err != nil {
+        t.Errorf("issued certificate: %v", err)
+    }
+
+    cached := a.Certificates()["groxy.test"]
+    if cached == nil || cached.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+        t.Error("issued certificate was not persisted to the cache")
+    }
+}
@@ -0,0 +1,145 @@
+package tls
+
+import (
+    "crypto"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// CAConfig describes a certificate authority created by GenerateCA.
+type CAConfig struct {
+    CommonName   string
+    Organization []string
+    Validity     time.Duration
+    KeyType      KeyType
+}
+
+// CA signs leaf certificates.
+type CA struct {
+    Cert *x509.Certificate
+    Key  crypto.Signer
+}
+
+// GenerateCA writes a new self-signed CA certificate and key.
+func GenerateCA(certFile, keyFile string, cfg CAConfig) error {
+    privateKey, err := GenerateKey(cfg.KeyType)
+    if err != nil {
+        return fmt.Errorf("failed to generate private key: %v", err)
+    }
+
+    serial, err := randomSerial()
+    if err != nil {
+        return err
+    }
+
+    template := x509.Certificate{
+        SerialNumber: serial,
+        Subject: pkix.Name{
+            CommonName:   cfg.CommonName,
+            Organization: cfg.Organization,
+        },
+        NotBefore:             time.Now().Add(-time.Minute),
+        NotAfter:              time.Now().Add(cfg.Validity),
+        KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+        BasicConstraintsValid: true,
+        IsCA:                  true,
+    }
+
+    certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+    if err != nil {
+        return fmt.Errorf("failed to create CA certificate: %v", err)
+    }
+
+    keyBlock, err := pkcs8Block(privateKey)
+    if err != nil {
+        return err
+    }
+    return writePair(certFile, keyFile, certBytes, keyBlock)
+}
+
+// LoadCA loads a CA certificate and its key. The certificate must have the
+// CA basic constraint and the certificate signing key usage.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+    pair, err := LoadKeyPair(certFile, keyFile, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load CA: %v", err)
+    }
+    if !pair.Leaf.IsCA || pair.Leaf.KeyUsage&x509.KeyUsageCertSign == 0 {
+        return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+    }
+    signer, ok := pair.PrivateKey.(crypto.Signer)
+    if !ok {
+        return nil, fmt.Errorf("CA key in %s cannot sign", keyFile)
+    }
+    return &CA{Cert: pair.Leaf, Key: signer}, nil
+}
+
+// randomSerial returns a random 128-bit serial number, as recommended by the
+// CA/Browser Forum baseline requirements.
+func randomSerial() (*big.Int, error) {
+    serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate serial number: %v", err)
+    }
+    return serial, nil
+}
+
+// writePair writes a DER certificate and a PEM key block, keeping the key
+// readable only by its owner. Each file is written to a temporary file in
+// the same directory and renamed over the old one, so a reader or a crash
+// never sees a truncated file. The key is replaced first; a reload that runs
+// between the two renames fails the key check and keeps the old pair.
+func writePair(certFile, keyFile string, certBytes []byte, keyBlock *pem.Block) error {
+    keyTemp, err := writeTemp(keyFile, pem.EncodeToMemory(keyBlock), 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write private key: %v", err)
+    }
+    certTemp, err := writeTemp(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0644)
+    if err != nil {
+        os.Remove(keyTemp)
+        return fmt.Errorf("failed to write certificate: %v", err)
+    }
+
+    if err := os.Rename(keyTemp, keyFile); err != nil {
+        os.Remove(keyTemp)
+        os.Remove(certTemp)
+        return fmt.Errorf("failed to replace %s: %v", keyFile, err)
+    }
+    if err := os.Rename(certTemp, certFile); err != nil {
+        os.Remove(certTemp)
+        return fmt.Errorf("failed to replace %s: %v", certFile, err)
+    }
+    return nil
+}
+
+// writeTemp writes data to a new file next to path and returns its name.
+func writeTemp(path string, data []byte, mode os.FileMode) (string, error) {
+    file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return "", err
+    }
+    name := file.Name()
+
+    err = file.Chmod(mode)
+    if err == nil {
+        _, err = file.Write(data)
+    }
+    if err == nil {
+        err = file.Sync()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(name)
+        return "", err
+    }
+    return name, nil
+}
@@ -0,0 +1,153 @@
+package tls
+
+import (
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "net"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestGenerateCertificateSignedByCA(t *testing.T) {
+    tests := []struct {
+        name       string
+        caValidity time.Duration
+        validity   time.Duration
+        clamped    bool
+    }{
+        {"within the CA lifetime", 365 * 24 * time.Hour, 24 * time.Hour, false},
+        {"clamped to the CA", time.Hour, 90 * 24 * time.Hour, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m := newTestManager(t)
+            if err := GenerateCA(m.config.CAFile, m.config.CAKeyFile, CAConfig{CommonName: "Test CA", Validity: tt.caValidity, KeyType: KeyECDSAP256}); err != nil {
+                t.Fatal(err)
+            }
+            ca, err := LoadCA(m.config.CAFile, m.config.CAKeyFile)
+            if err != nil {
+                t.Fatal(err)
+            }
+            m.config.CertConfig.Validity = tt.validity
+            m.config.CertConfig.DNSNames = []string{"proxy.test", "localhost"}
+            m.config.CertConfig.IPAddresses = []net.IP{net.IPv4(192, 0, 2, 10), net.IPv6loopback}
+
+            start := time.Now().Truncate(time.Second)
+            if err := m.GenerateCertificate(); err != nil {
+                t.Fatal(err)
+            }
+            pair, err := LoadKeyPair(m.config.CertFile, m.config.KeyFile, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            leaf := pair.Leaf
+
+            roots := x509.NewCertPool()
+            roots.AddCert(ca.Cert)
+            for _, name := range []string{"proxy.test", "localhost", "192.0.2.10", "::1"} {
+                if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+                    t.Errorf("Verify(%s) error = %v", name, err)
+                }
+            }
+            if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "other.test", Roots: roots}); err == nil {
+                t.Error("certificate verified for a name it does not carry")
+            }
+            if leaf.Issuer.String() != ca.Cert.Subject.String() {
+                t.Errorf("issuer = %q, want %q", leaf.Issuer, ca.Cert.Subject)
+            }
+            if leaf.IsCA {
+                t.Error("leaf certificate is marked as a CA")
+            }
+            if leaf.SerialNumber.Sign() <= 0 || leaf.SerialNumber.BitLen() > 128 || leaf.SerialNumber.Cmp(ca.Cert.SerialNumber) == 0 {
+                t.Errorf("serial %s is not a fresh positive 128-bit number", leaf.SerialNumber.Text(16))
+            }
+
+            if leaf.NotBefore.Before(start) || leaf.NotBefore.After(time.Now()) {
+                t.Errorf("NotBefore = %s, want the time of issuance", leaf.NotBefore)
+            }
+            wantNotAfter := leaf.NotBefore.Add(tt.validity)
+            if tt.clamped {
+                wantNotAfter = ca.Cert.NotAfter
+            }
+            if diff := leaf.NotAfter.Sub(wantNotAfter); diff < -time.Second || diff > time.Second {
+                t.Errorf("NotAfter = %s, want %s", leaf.NotAfter, wantNotAfter)
+            }
+            if leaf.NotAfter.After(ca.Cert.NotAfter) {
+                t.Error("certificate outlives its CA")
+            }
+        })
+    }
+}
+
+func TestGenerateCertificateRandomSerial(t *testing.T) {
+    m := newTestManager(t)
+    seen := make(map[string]bool)
+    for i := 0; i < 3; i++ {
+        if err := m.GenerateCertificate(); err != nil {
+            t.Fatal(err)
+        }
+        pair, err := LoadKeyPair(m.config.CertFile, m.config.KeyFile, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        serial := pair.Leaf.SerialNumber.Text(16)
+        if seen[serial] {
+            t.Fatalf("serial %s issued twice", serial)
+        }
+        seen[serial] = true
+    }
+}
+
+func TestLoadCARequiresSigningCA(t *testing.T) {
+    tests := []struct {
+        name     string
+        isCA     bool
+        keyUsage x509.KeyUsage
+        wantErr  bool
+    }{
+        {"signing CA", true, x509.KeyUsageCertSign | x509.KeyUsageCRLSign, false},
+        {"not a CA", false, x509.KeyUsageCertSign, true},
+        {"CA without key usage", true, 0, true},
+        {"CA without certificate signing", true, x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            key, err := GenerateKey(KeyECDSAP256)
+            if err != nil {
+                t.Fatal(err)
+            }
+            serial, err := randomSerial()
+            if err != nil {
+                t.Fatal(err)
+            }
+            template := &x509.Certificate{
+                SerialNumber:          serial,
+                Subject:               pkix.Name{CommonName: tt.name},
+                NotBefore:             time.Now().Add(-time.Hour),
+                NotAfter:              time.Now().Add(time.Hour),
+                BasicConstraintsValid: true,
+                IsCA:                  tt.isCA,
+                KeyUsage:              tt.keyUsage,
+            }
+            der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+            if err != nil {
+                t.Fatal(err)
+            }
+            keyBlock, err := pkcs8Block(key)
+            if err != nil {
+                t.Fatal(err)
+            }
+            dir := t.TempDir()
+            certFile, keyFile := filepath.Join(dir, "ca-cert.pem"), filepath.Join(dir, "ca-key.pem")
+            if err := writePair(certFile, keyFile, der, keyBlock); err != nil {
+                t.Fatal(err)
+            }
+
+            if _, err := LoadCA(certFile, keyFile); (err != nil) != tt.wantErr {
+                t.Errorf("LoadCA() error = %v, wantErr %t", err, tt.wantErr)
+            }
+        })
+    }
+}
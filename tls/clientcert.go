@@ -0,0 +1,83 @@
+package tls
+
+import (
+    cryptotls "crypto/tls"
+    "fmt"
+    "sync"
+    "time"
+
+    "Groxy/watcher"
+)
+
+// ClientCertManager holds the client certificate presented to an upstream.
+// Like Manager it swaps certificates atomically and reports through
+// callbacks; the certificate and key files are reloaded when they change.
+type ClientCertManager struct {
+    certFile    string
+    keyFile     string
+    password    []byte
+    currentCert *cryptotls.Certificate
+    certMutex   sync.RWMutex
+    watcher     *watcher.FileWatcher
+    OnReload    func(*cryptotls.Certificate)
+    OnError     func(error)
+}
+
+func NewClientCertManager(certFile, keyFile string, password []byte) (*ClientCertManager, error) {
+    m := &ClientCertManager{
+        certFile: certFile,
+        keyFile:  keyFile,
+        password: password,
+    }
+    if err := m.Reload(); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+// Reload loads the files again. On error the current certificate stays in
+// use.
+func (m *ClientCertManager) Reload() error {
+    cert, err := LoadKeyPair(m.certFile, m.keyFile, m.password)
+    if err != nil {
+        return fmt.Errorf("failed to load client certificate %s: %v", m.certFile, err)
+    }
+
+    m.certMutex.Lock()
+    m.currentCert = &cert
+    m.certMutex.Unlock()
+    return nil
+}
+
+func (m *ClientCertManager) Certificate() *cryptotls.Certificate {
+    m.certMutex.RLock()
+    defer m.certMutex.RUnlock()
+    return m.currentCert
+}
+
+// GetClientCertificate is meant for tls.Config.GetClientCertificate.
+func (m *ClientCertManager) GetClientCertificate(*cryptotls.CertificateRequestInfo) (*cryptotls.Certificate, error) {
+    return m.Certificate(), nil
+}
+
+func (m *ClientCertManager) StartWatching(interval time.Duration) {
+    m.watcher = watcher.NewFileWatcher(interval, m.certFile, m.keyFile)
+    m.watcher.OnChange = func() {
+        if err := m.Reload(); err != nil {
+            if m.OnError != nil {
+                m.OnError(err)
+            }
+            return
+        }
+        if m.OnReload != nil {
+            m.OnReload(m.Certificate())
+        }
+    }
+    m.watcher.Start()
+}
+
+func (m *ClientCertManager) StopWatching() {
+    if m.watcher != nil {
+        m.watcher.Stop()
+    }
+}
@@ -2,7 +2,10 @@ package tls
 
 import (
     cryptotls "crypto/tls"
+    "crypto/x509"
     "fmt"
+    "net"
+    "os"
     "time"
 )
 
@@ -10,6 +13,19 @@ type Config struct {
     CertFile string
     KeyFile  string
     CertConfig CertificateConfig
+    // ClientCAFile, when set, makes the HTTPS listener request client
+    // certificates and verify any it receives against this bundle.
+    ClientCAFile string
+    // CAFile and CAKeyFile sign generated certificates. When either file
+    // is missing, generated certificates are self-signed.
+    CAFile    string
+    CAKeyFile string
+    // ServerPolicy applies to the HTTPS listener and UpstreamPolicy to
+    // connections to targets.
+    ServerPolicy   *Policy
+    UpstreamPolicy *Policy
+    // TicketKeyRotation is how often the session ticket key is replaced.
+    TicketKeyRotation time.Duration
 }
 
 type CertificateConfig struct {
@@ -17,43 +33,88 @@ type CertificateConfig struct {
     Organization []string
     Country      []string
     Validity     time.Duration
-    KeySize      int
+    KeyType      KeyType
     IsCA         bool
+    DNSNames     []string
+    IPAddresses  []net.IP
 }
 
 func NewConfig(certFile, keyFile string) *Config {
     return &Config{
         CertFile: certFile,
         KeyFile:  keyFile,
+        CAFile:    "certs/ca-cert.pem",
+        CAKeyFile: "certs/ca-key.pem",
+        TicketKeyRotation: 24 * time.Hour,
         CertConfig: CertificateConfig{
             CommonName:   "localhost",
             Organization: []string{"YourOrg"},
             Country:     []string{"US"},
             Validity:    time.Hour * 24 * 90, // 90 days
-            KeySize:     2048,
+            KeyType:     KeyRSA2048,
             IsCA:        false,
+            DNSNames:    []string{"localhost"},
+            IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
         },
     }
 }
 
+// LoadServerConfig returns the HTTPS listener config. Every handshake asks
+// getCertificate, so certificates swapped in later are picked up even by
+// clients that send no SNI.
 func (c *Config) LoadServerConfig(getCertificate func(*cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error)) (*cryptotls.Config, error) {
-    cert, err := cryptotls.LoadX509KeyPair(c.CertFile, c.KeyFile)
-    if err != nil {
-        return nil, fmt.Errorf("failed to load key pair: %v", err)
+    serverConfig := &cryptotls.Config{
+        GetCertificate: getCertificate,
     }
+    c.serverPolicy().applyServer(serverConfig)
 
-    return &cryptotls.Config{
-        Certificates: []cryptotls.Certificate{cert},
-        GetCertificate: getCertificate,
-        MinVersion:     cryptotls.VersionTLS12,
-    }, nil
+    if c.ClientCAFile != "" {
+        pool, err := loadCertPool(c.ClientCAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load client CA bundle: %v", err)
+        }
+        serverConfig.ClientCAs = pool
+        // Certificates are optional at the TLS layer so that routes without
+        // mTLS keep working; the auth method rejects requests lacking one.
+        serverConfig.ClientAuth = cryptotls.VerifyClientCertIfGiven
+    }
+
+    return serverConfig, nil
 }
 
+func loadCertPool(path string) (*x509.CertPool, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        return nil, fmt.Errorf("no certificates found in %s", path)
+    }
+    return pool, nil
+}
+
+// LoadClientConfig returns the config for upstream connections, which are
+// verified against the system roots. See LoadUpstreamConfig for custom CAs,
+// pins and opting out of verification.
 func (c *Config) LoadClientConfig() *cryptotls.Config {
-    return &cryptotls.Config{
-        InsecureSkipVerify: true, // Only for development
-        MinVersion:         cryptotls.VersionTLS12,
+    config := &cryptotls.Config{}
+    c.upstreamPolicy().applyClient(config)
+    return config
+}
+
+func (c *Config) serverPolicy() *Policy {
+    if c.ServerPolicy != nil {
+        return c.ServerPolicy
+    }
+    return Policies["intermediate"].clone()
+}
+
+func (c *Config) upstreamPolicy() *Policy {
+    if c.UpstreamPolicy != nil {
+        return c.UpstreamPolicy
     }
+    return Policies["intermediate"].clone()
 }
 
 func (c *Config) GetCertificateConfig() CertificateConfig {
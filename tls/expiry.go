@@ -0,0 +1,287 @@
+package tls
+
+import (
+    "bytes"
+    "context"
+    cryptotls "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "os/exec"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "Groxy/logger"
+)
+
+// DefaultExpiryThresholds warn 30, 7 and 1 days before expiry.
+var DefaultExpiryThresholds = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
+// ExpiryConfig configures the expiry monitor. Alerts fire once per
+// certificate and threshold; a renewed certificate starts over.
+type ExpiryConfig struct {
+    Thresholds []time.Duration
+    Interval   time.Duration
+    // WebhookURL receives each alert as a JSON POST.
+    WebhookURL string
+    // Command is run through sh -c with the alert in GROXY_CERT_*
+    // environment variables.
+    Command string
+}
+
+// CertificateStatus describes one tracked certificate.
+type CertificateStatus struct {
+    Name     string    `json:"name"`
+    Role     string    `json:"role"`
+    Subject  string    `json:"subject"`
+    Issuer   string    `json:"issuer"`
+    Serial   string    `json:"serial"`
+    DNSNames []string  `json:"dns_names,omitempty"`
+    NotAfter time.Time `json:"not_after"`
+    DaysLeft float64   `json:"days_left"`
+    Expired  bool      `json:"expired"`
+}
+
+// ExpiryAlert is sent to hooks when a certificate crosses a threshold.
+// ThresholdDays is 0 for the alert sent once the certificate has expired.
+type ExpiryAlert struct {
+    CertificateStatus
+    ThresholdDays float64 `json:"threshold_days"`
+}
+
+func certificateStatus(name, role string, cert *cryptotls.Certificate, now time.Time) CertificateStatus {
+    leaf := cert.Leaf
+    return CertificateStatus{
+        Name:     name,
+        Role:     role,
+        Subject:  leaf.Subject.String(),
+        Issuer:   leaf.Issuer.String(),
+        Serial:   leaf.SerialNumber.Text(16),
+        DNSNames: leaf.DNSNames,
+        NotAfter: leaf.NotAfter,
+        DaysLeft: leaf.NotAfter.Sub(now).Hours() / 24,
+        Expired:  now.After(leaf.NotAfter),
+    }
+}
+
+// TrackClientCert adds an upstream client certificate to expiry monitoring.
+func (m *Manager) TrackClientCert(name string, cert *ClientCertManager) {
+    m.expiryMutex.Lock()
+    defer m.expiryMutex.Unlock()
+    if m.clientCerts == nil {
+        m.clientCerts = make(map[string]*ClientCertManager)
+    }
+    m.clientCerts[name] = cert
+}
+
+// Certificates reports every served and upstream client certificate,
+// including those issued through ACME. ACME renews its certificates itself,
+// but a renewal that keeps failing should still raise an alert.
+func (m *Manager) Certificates() []CertificateStatus {
+    now := time.Now()
+    var statuses []CertificateStatus
+
+    m.certMutex.RLock()
+    current := m.currentCert
+    m.certMutex.RUnlock()
+    if current != nil && current.Leaf != nil {
+        statuses = append(statuses, certificateStatus(m.config.CertFile, "server", current, now))
+    }
+
+    if m.store != nil {
+        var stored []CertificateStatus
+        for _, cert := range m.store.Certificates() {
+            name := cert.Leaf.Subject.CommonName
+            if len(cert.Leaf.DNSNames) > 0 {
+                name = cert.Leaf.DNSNames[0]
+            }
+            stored = append(stored, certificateStatus(name, "server", cert, now))
+        }
+        slices.SortFunc(stored, func(a, b CertificateStatus) int { return strings.Compare(a.Name, b.Name) })
+        statuses = append(statuses, stored...)
+    }
+
+    if m.acme != nil {
+        var issued []CertificateStatus
+        for name, cert := range m.acme.Certificates() {
+            issued = append(issued, certificateStatus(name, "acme", cert, now))
+        }
+        slices.SortFunc(issued, func(a, b CertificateStatus) int { return strings.Compare(a.Name, b.Name) })
+        statuses = append(statuses, issued...)
+    }
+
+    m.expiryMutex.Lock()
+    names := make([]string, 0, len(m.clientCerts))
+    for name := range m.clientCerts {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+    for _, name := range names {
+        if cert := m.clientCerts[name].Certificate(); cert != nil {
+            statuses = append(statuses, certificateStatus(name, "client", cert, now))
+        }
+    }
+    m.expiryMutex.Unlock()
+
+    return statuses
+}
+
+// StartExpiryMonitor checks certificates now and then every Interval.
+func (m *Manager) StartExpiryMonitor(config ExpiryConfig) {
+    m.StopExpiryMonitor()
+
+    thresholds := slices.Clone(config.Thresholds)
+    if len(thresholds) == 0 {
+        thresholds = slices.Clone(DefaultExpiryThresholds)
+    }
+    slices.Sort(thresholds)
+    slices.Reverse(thresholds)
+    config.Thresholds = thresholds
+    if config.Interval <= 0 {
+        config.Interval = time.Hour
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    m.expiryMutex.Lock()
+    m.expiryCancel = cancel
+    m.alerted = make(map[string]int)
+    m.expiryMutex.Unlock()
+
+    go func() {
+        ticker := time.NewTicker(config.Interval)
+        defer ticker.Stop()
+
+        for {
+            m.checkExpiry(config)
+            select {
+            case <-ticker.C:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+}
+
+func (m *Manager) StopExpiryMonitor() {
+    m.expiryMutex.Lock()
+    defer m.expiryMutex.Unlock()
+    if m.expiryCancel != nil {
+        m.expiryCancel()
+        m.expiryCancel = nil
+    }
+}
+
+// expiryLevel returns the index of the shortest threshold remaining is
+// within, len(thresholds) once expired, or -1. Thresholds are sorted longest
+// first, so a higher level is more urgent.
+func expiryLevel(thresholds []time.Duration, remaining time.Duration) int {
+    if remaining <= 0 {
+        return len(thresholds)
+    }
+    level := -1
+    for i, threshold := range thresholds {
+        if remaining <= threshold {
+            level = i
+        }
+    }
+    return level
+}
+
+// checkExpiry alerts for every certificate that reached a level it has not
+// been alerted for yet. Expiry is a level of its own, so a certificate that
+// was alerted at the last threshold alerts again when it expires.
+func (m *Manager) checkExpiry(config ExpiryConfig) {
+    for _, status := range m.Certificates() {
+        level := expiryLevel(config.Thresholds, time.Until(status.NotAfter))
+        if level < 0 {
+            continue
+        }
+
+        key := status.Name + "/" + status.Serial
+        m.expiryMutex.Lock()
+        previous, seen := m.alerted[key]
+        if seen && previous >= level {
+            m.expiryMutex.Unlock()
+            continue
+        }
+        m.alerted[key] = level
+        m.expiryMutex.Unlock()
+
+        if status.Expired {
+            logger.Error("Certificate %s (%s) EXPIRED on %s", status.Name, status.Subject, status.NotAfter.Format(time.RFC3339))
+        } else if level == len(config.Thresholds)-1 {
+            logger.Error("Certificate %s (%s) expires in %.1f days on %s", status.Name, status.Subject, status.DaysLeft, status.NotAfter.Format(time.RFC3339))
+        } else {
+            logger.Warning("Certificate %s (%s) expires in %.1f days on %s", status.Name, status.Subject, status.DaysLeft, status.NotAfter.Format(time.RFC3339))
+        }
+
+        alert := ExpiryAlert{CertificateStatus: status}
+        if level < len(config.Thresholds) {
+            alert.ThresholdDays = config.Thresholds[level].Hours() / 24
+        }
+        if config.WebhookURL != "" {
+            if err := postAlert(config.WebhookURL, alert); err != nil {
+                logger.Error("Certificate expiry webhook failed: %v", err)
+            }
+        }
+        if config.Command != "" {
+            if err := runAlertCommand(config.Command, alert); err != nil {
+                logger.Error("Certificate expiry command failed: %v", err)
+            }
+        }
+    }
+}
+
+func postAlert(url string, alert ExpiryAlert) error {
+    body, err := json.Marshal(alert)
+    if err != nil {
+        return err
+    }
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
+
+func runAlertCommand(command string, alert ExpiryAlert) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, "sh", "-c", command)
+    cmd.Env = append(os.Environ(),
+        "GROXY_CERT_NAME="+alert.Name,
+        "GROXY_CERT_ROLE="+alert.Role,
+        "GROXY_CERT_SUBJECT="+alert.Subject,
+        "GROXY_CERT_SERIAL="+alert.Serial,
+        "GROXY_CERT_NOT_AFTER="+alert.NotAfter.Format(time.RFC3339),
+        "GROXY_CERT_DAYS_LEFT="+strconv.FormatFloat(alert.DaysLeft, 'f', 1, 64),
+        "GROXY_CERT_THRESHOLD_DAYS="+strconv.FormatFloat(alert.ThresholdDays, 'f', -1, 64),
+        "GROXY_CERT_EXPIRED="+strconv.FormatBool(alert.Expired),
+    )
+    if output, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+    }
+    return nil
+}
+
+// StatusHandler serves the tracked certificates as JSON.
+func (m *Manager) StatusHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            w.Header().Set("Allow", http.MethodGet)
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]any{"certificates": m.Certificates()})
+    })
+}
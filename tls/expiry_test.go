@@ -0,0 +1,161 @@
+package tls
+
+import (
+    "crypto/rand"
+    cryptotls "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/json"
+    "encoding/pem"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+// testCertificate returns a self-signed certificate for name expiring at
+// notAfter, and its key as a PEM block.
+func testCertificate(t *testing.T, name string, notAfter time.Time) (*cryptotls.Certificate, *pem.Block) {
+    t.Helper()
+    key, err := GenerateKey(KeyECDSAP256)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial, err := randomSerial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: serial,
+        Subject:      pkix.Name{CommonName: name},
+        DNSNames:     []string{name},
+        NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+        NotAfter:     notAfter,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyBlock, err := pkcs8Block(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &cryptotls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, keyBlock
+}
+
+func TestExpiryLevel(t *testing.T) {
+    day := 24 * time.Hour
+    thresholds := []time.Duration{30 * day, 7 * day, day}
+
+    tests := []struct {
+        name      string
+        remaining time.Duration
+        want      int
+    }{
+        {"far from expiry", 60 * day, -1},
+        {"first threshold", 20 * day, 0},
+        {"second threshold", 7 * day, 1},
+        {"last threshold", 12 * time.Hour, 2},
+        {"expired now", 0, 3},
+        {"expired long ago", -10 * day, 3},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := expiryLevel(thresholds, tt.remaining); got != tt.want {
+                t.Errorf("expiryLevel(%v) = %d, want %d", tt.remaining, got, tt.want)
+            }
+        })
+    }
+}
+
+type alertRecorder struct {
+    mu     sync.Mutex
+    alerts []ExpiryAlert
+}
+
+func (r *alertRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    var alert ExpiryAlert
+    if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    r.mu.Lock()
+    r.alerts = append(r.alerts, alert)
+    r.mu.Unlock()
+}
+
+func (r *alertRecorder) take() []ExpiryAlert {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    alerts := r.alerts
+    r.alerts = nil
+    return alerts
+}
+
+func TestCheckExpiryAlertsAgainOnExpiry(t *testing.T) {
+    recorder := &alertRecorder{}
+    server := httptest.NewServer(recorder)
+    defer server.Close()
+
+    config := ExpiryConfig{
+        Thresholds: []time.Duration{7 * 24 * time.Hour, 24 * time.Hour},
+        WebhookURL: server.URL,
+    }
+    m := NewManager(&Config{CertFile: "server.pem"})
+    m.alerted = make(map[string]int)
+
+    cert, _ := testCertificate(t, "groxy.test", time.Now().Add(time.Hour))
+    m.currentCert = cert
+    m.checkExpiry(config)
+    alerts := recorder.take()
+    if len(alerts) != 1 || alerts[0].ThresholdDays != 1 || alerts[0].Expired {
+        t.Fatalf("alerts before expiry = %+v, want one at the last threshold", alerts)
+    }
+
+    m.checkExpiry(config)
+    if alerts := recorder.take(); len(alerts) != 0 {
+        t.Fatalf("repeated alerts for the same threshold: %+v", alerts)
+    }
+
+    // Same certificate, now past its expiry.
+    expired, _ := testCertificate(t, "groxy.test", time.Now().Add(-time.Minute))
+    expired.Leaf.SerialNumber = cert.Leaf.SerialNumber
+    m.currentCert = expired
+    m.checkExpiry(config)
+    alerts = recorder.take()
+    if len(alerts) != 1 || alerts[0].ThresholdDays != 0 || !alerts[0].Expired {
+        t.Fatalf("alerts after expiry = %+v, want one expired alert", alerts)
+    }
+}
+
+func TestACMECertificatesFromCache(t *testing.T) {
+    cacheDir := t.TempDir()
+    cert, keyBlock := testCertificate(t, "issued.example.com", time.Now().Add(12*time.Hour))
+    entry := pem.EncodeToMemory(keyBlock)
+    entry = append(entry, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})...)
+    if err := os.WriteFile(filepath.Join(cacheDir, "issued.example.com"), entry, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    a, err := NewACME(ACMEConfig{Domains: []string{"issued.example.com", "pending.example.com"}, CacheDir: cacheDir})
+    if err != nil {
+        t.Fatal(err)
+    }
+    m := NewManager(&Config{})
+    m.SetACME(a)
+
+    statuses := m.Certificates()
+    if len(statuses) != 1 {
+        t.Fatalf("Certificates() = %+v, want only the issued domain", statuses)
+    }
+    if statuses[0].Name != "issued.example.com" || statuses[0].Role != "acme" || statuses[0].Serial != cert.Leaf.SerialNumber.Text(16) {
+        t.Errorf("Certificates()[0] = %+v", statuses[0])
+    }
+}
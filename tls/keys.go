@@ -0,0 +1,242 @@
+package tls
+
+import (
+    "crypto"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/des"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha1"
+    "crypto/sha256"
+    cryptotls "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    "encoding/pem"
+    "fmt"
+    "hash"
+    "os"
+
+    "golang.org/x/crypto/pbkdf2"
+)
+
+var (
+    oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+    oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+    oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+    oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+    oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+    oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+    oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+    oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// KeyType names the algorithm and size of a generated private key.
+type KeyType string
+
+const (
+    KeyRSA2048   KeyType = "rsa2048"
+    KeyRSA3072   KeyType = "rsa3072"
+    KeyRSA4096   KeyType = "rsa4096"
+    KeyECDSAP256 KeyType = "p256"
+    KeyECDSAP384 KeyType = "p384"
+    KeyEd25519   KeyType = "ed25519"
+)
+
+// KeyTypes lists the supported key types.
+var KeyTypes = []KeyType{KeyRSA2048, KeyRSA3072, KeyRSA4096, KeyECDSAP256, KeyECDSAP384, KeyEd25519}
+
+// GenerateKey creates a private key of the given type.
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+    switch keyType {
+    case KeyRSA2048:
+        return rsa.GenerateKey(rand.Reader, 2048)
+    case KeyRSA3072:
+        return rsa.GenerateKey(rand.Reader, 3072)
+    case KeyRSA4096:
+        return rsa.GenerateKey(rand.Reader, 4096)
+    case KeyECDSAP256:
+        return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    case KeyECDSAP384:
+        return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+    case KeyEd25519:
+        _, key, err := ed25519.GenerateKey(rand.Reader)
+        return key, err
+    default:
+        return nil, fmt.Errorf("unsupported key type %q", keyType)
+    }
+}
+
+// keyUsage returns the key usages a leaf with key may carry; key
+// encipherment only applies to RSA key exchange.
+func keyUsage(key crypto.Signer) x509.KeyUsage {
+    if _, ok := key.(*rsa.PrivateKey); ok {
+        return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+    }
+    return x509.KeyUsageDigitalSignature
+}
+
+// pkcs8Block encodes key as an unencrypted PKCS#8 PEM block.
+func pkcs8Block(key crypto.Signer) (*pem.Block, error) {
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal private key: %v", err)
+    }
+    return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+type encryptedPrivateKeyInfo struct {
+    Algorithm     pkix.AlgorithmIdentifier
+    EncryptedData []byte
+}
+
+type pbes2Params struct {
+    KeyDerivationFunc pkix.AlgorithmIdentifier
+    EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+    Salt           []byte
+    IterationCount int
+    KeyLength      int                      `asn1:"optional"`
+    PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// LoadKeyPair loads a certificate chain and its private key. RSA, ECDSA and
+// Ed25519 keys are accepted in PKCS#1, SEC 1 or PKCS#8 form. The key may be
+// an encrypted PEM, either legacy OpenSSL ("Proc-Type: 4,ENCRYPTED") or
+// PKCS#8 "ENCRYPTED PRIVATE KEY" with PBES2, in which case password is
+// required.
+func LoadKeyPair(certFile, keyFile string, password []byte) (cryptotls.Certificate, error) {
+    certPEM, err := os.ReadFile(certFile)
+    if err != nil {
+        return cryptotls.Certificate{}, fmt.Errorf("failed to read certificate: %v", err)
+    }
+    keyPEM, err := os.ReadFile(keyFile)
+    if err != nil {
+        return cryptotls.Certificate{}, fmt.Errorf("failed to read key: %v", err)
+    }
+
+    block, _ := pem.Decode(keyPEM)
+    if block == nil {
+        return cryptotls.Certificate{}, fmt.Errorf("no PEM data found in %s", keyFile)
+    }
+    if block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) {
+        if len(password) == 0 {
+            return cryptotls.Certificate{}, fmt.Errorf("key %s is encrypted but no password was given", keyFile)
+        }
+        der, err := decryptPrivateKey(block, password)
+        if err != nil {
+            return cryptotls.Certificate{}, fmt.Errorf("failed to decrypt %s: %v", keyFile, err)
+        }
+        keyType := "PRIVATE KEY"
+        if block.Type != "ENCRYPTED PRIVATE KEY" {
+            keyType = block.Type
+        }
+        keyPEM = pem.EncodeToMemory(&pem.Block{Type: keyType, Bytes: der})
+    }
+
+    cert, err := cryptotls.X509KeyPair(certPEM, keyPEM)
+    if err != nil {
+        return cryptotls.Certificate{}, err
+    }
+    cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return cryptotls.Certificate{}, err
+    }
+    return cert, nil
+}
+
+func decryptPrivateKey(block *pem.Block, password []byte) ([]byte, error) {
+    if block.Type != "ENCRYPTED PRIVATE KEY" {
+        //lint:ignore SA1019 legacy encrypted PEM keys are still common
+        return x509.DecryptPEMBlock(block, password)
+    }
+
+    var info encryptedPrivateKeyInfo
+    if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+        return nil, fmt.Errorf("invalid encrypted private key: %v", err)
+    }
+    if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+        return nil, fmt.Errorf("unsupported key encryption %v, only PBES2 is supported", info.Algorithm.Algorithm)
+    }
+
+    var params pbes2Params
+    if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+        return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+    }
+    if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+        return nil, fmt.Errorf("unsupported key derivation %v", params.KeyDerivationFunc.Algorithm)
+    }
+    var kdf pbkdf2Params
+    if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+        return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+    }
+
+    var prf func() hash.Hash
+    switch {
+    case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+        prf = sha1.New
+    case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+        prf = sha256.New
+    default:
+        return nil, fmt.Errorf("unsupported PBKDF2 PRF %v", kdf.PRF.Algorithm)
+    }
+
+    var keyLength int
+    var newCipher func([]byte) (cipher.Block, error)
+    scheme := params.EncryptionScheme.Algorithm
+    switch {
+    case scheme.Equal(oidAES128CBC):
+        keyLength, newCipher = 16, aes.NewCipher
+    case scheme.Equal(oidAES192CBC):
+        keyLength, newCipher = 24, aes.NewCipher
+    case scheme.Equal(oidAES256CBC):
+        keyLength, newCipher = 32, aes.NewCipher
+    case scheme.Equal(oidDESEDE3CBC):
+        keyLength, newCipher = 24, des.NewTripleDESCipher
+    default:
+        return nil, fmt.Errorf("unsupported encryption scheme %v", scheme)
+    }
+    if kdf.KeyLength != 0 && kdf.KeyLength != keyLength {
+        return nil, fmt.Errorf("key length %d does not match the cipher", kdf.KeyLength)
+    }
+
+    var iv []byte
+    if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+        return nil, fmt.Errorf("invalid cipher IV: %v", err)
+    }
+
+    key := pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLength, prf)
+    blockCipher, err := newCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(iv) != blockCipher.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%blockCipher.BlockSize() != 0 {
+        return nil, fmt.Errorf("malformed encrypted key data")
+    }
+
+    data := make([]byte, len(info.EncryptedData))
+    cipher.NewCBCDecrypter(blockCipher, iv).CryptBlocks(data, info.EncryptedData)
+
+    // A wrong password almost always shows up as bad padding.
+    padding := int(data[len(data)-1])
+    if padding == 0 || padding > blockCipher.BlockSize() {
+        return nil, x509.IncorrectPasswordError
+    }
+    for _, b := range data[len(data)-padding:] {
+        if int(b) != padding {
+            return nil, x509.IncorrectPasswordError
+        }
+    }
+    data = data[:len(data)-padding]
+
+    if _, err := x509.ParsePKCS8PrivateKey(data); err != nil {
+        return nil, x509.IncorrectPasswordError
+    }
+    return data, nil
+}
@@ -0,0 +1,230 @@
+package tls
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/pem"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+const testKeyPassword = "groxy-test"
+
+func readPEM(t *testing.T, name string) *pem.Block {
+    t.Helper()
+    data, err := os.ReadFile(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        t.Fatalf("no PEM data in %s", name)
+    }
+    return block
+}
+
+// parseTestKey parses der as PKCS#8, PKCS#1 or SEC 1.
+func parseTestKey(der []byte) (crypto.Signer, error) {
+    if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+        return key.(crypto.Signer), nil
+    }
+    if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+        return key, nil
+    }
+    return x509.ParseECPrivateKey(der)
+}
+
+// The encrypted fixtures in testdata were produced by openssl from
+// ec-key.pem and rsa-key.pem with the password "groxy-test", e.g.
+//
+//    openssl pkcs8 -topk8 -in ec-key.pem -v2 aes-256-cbc -v2prf hmacWithSHA256 -out pkcs8-aes-256-cbc-hmacWithSHA256.pem
+//    openssl rsa -in rsa-key.pem -traditional -des3 -out traditional-rsa-des3.pem
+func TestDecryptPrivateKey(t *testing.T) {
+    tests := []struct {
+        file  string
+        plain string
+    }{
+        {"pkcs8-aes-128-cbc-hmacWithSHA1.pem", "ec-key.pem"},
+        {"pkcs8-aes-128-cbc-hmacWithSHA256.pem", "ec-key.pem"},
+        {"pkcs8-aes-256-cbc-hmacWithSHA1.pem", "ec-key.pem"},
+        {"pkcs8-aes-256-cbc-hmacWithSHA256.pem", "ec-key.pem"},
+        {"pkcs8-des3-hmacWithSHA256.pem", "rsa-key.pem"},
+        {"traditional-ec-aes128.pem", "ec-key.pem"},
+        {"traditional-rsa-aes256.pem", "rsa-key.pem"},
+        {"traditional-rsa-des3.pem", "rsa-key.pem"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.file, func(t *testing.T) {
+            want, err := parseTestKey(readPEM(t, tt.plain).Bytes)
+            if err != nil {
+                t.Fatal(err)
+            }
+            block := readPEM(t, tt.file)
+
+            der, err := decryptPrivateKey(block, []byte(testKeyPassword))
+            if err != nil {
+                t.Fatalf("decryptPrivateKey() error = %v", err)
+            }
+            got, err := parseTestKey(der)
+            if err != nil {
+                t.Fatalf("decrypted key does not parse: %v", err)
+            }
+            if !got.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(want.Public()) {
+                t.Error("decrypted key does not match the original")
+            }
+
+            if _, err := decryptPrivateKey(block, []byte("wrong password")); err == nil {
+                t.Error("decryptPrivateKey() accepted a wrong password")
+            }
+        })
+    }
+}
+
+func TestDecryptPrivateKeyRejectsDamagedData(t *testing.T) {
+    block := readPEM(t, "pkcs8-aes-256-cbc-hmacWithSHA256.pem")
+    tests := []struct {
+        name  string
+        bytes []byte
+    }{
+        {"truncated", block.Bytes[:len(block.Bytes)-7]},
+        {"not ASN.1", []byte("not a key")},
+        {"empty", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            damaged := &pem.Block{Type: block.Type, Bytes: tt.bytes}
+            if _, err := decryptPrivateKey(damaged, []byte(testKeyPassword)); err == nil {
+                t.Error("decryptPrivateKey() accepted damaged data")
+            }
+        })
+    }
+}
+
+func TestLoadKeyPairEncrypted(t *testing.T) {
+    issuer := newTestIssuer(t, "Test CA")
+    key, err := parseTestKey(readPEM(t, "ec-key.pem").Bytes)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := *issuer.cert
+    template.IsCA = false
+    template.KeyUsage = x509.KeyUsageDigitalSignature
+    der, err := x509.CreateCertificate(rand.Reader, &template, issuer.cert, key.Public(), issuer.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    certFile := filepath.Join(t.TempDir(), "cert.pem")
+    if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name     string
+        file     string
+        password string
+        wantErr  bool
+    }{
+        {"unencrypted", "ec-key.pem", "", false},
+        {"unencrypted with a password", "ec-key.pem", testKeyPassword, false},
+        {"pkcs8", "pkcs8-aes-128-cbc-hmacWithSHA256.pem", testKeyPassword, false},
+        {"traditional", "traditional-ec-aes128.pem", testKeyPassword, false},
+        {"pkcs8 without a password", "pkcs8-aes-128-cbc-hmacWithSHA256.pem", "", true},
+        {"traditional without a password", "traditional-ec-aes128.pem", "", true},
+        {"wrong password", "pkcs8-aes-128-cbc-hmacWithSHA256.pem", "wrong password", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := LoadKeyPair(certFile, filepath.Join("testdata", tt.file), []byte(tt.password))
+            if (err != nil) != tt.wantErr {
+                t.Errorf("LoadKeyPair() error = %v, wantErr %t", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestGenerateKey(t *testing.T) {
+    tests := []struct {
+        keyType  KeyType
+        check    func(key crypto.Signer) bool
+        keyUsage x509.KeyUsage
+    }{
+        {KeyRSA2048, rsaBits(2048), x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature},
+        {KeyRSA3072, rsaBits(3072), x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature},
+        {KeyRSA4096, rsaBits(4096), x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature},
+        {KeyECDSAP256, ecdsaCurve(elliptic.P256()), x509.KeyUsageDigitalSignature},
+        {KeyECDSAP384, ecdsaCurve(elliptic.P384()), x509.KeyUsageDigitalSignature},
+        {KeyEd25519, func(key crypto.Signer) bool { _, ok := key.(ed25519.PrivateKey); return ok }, x509.KeyUsageDigitalSignature},
+    }
+    if len(tests) != len(KeyTypes) {
+        t.Fatalf("%d key types tested, but KeyTypes lists %d", len(tests), len(KeyTypes))
+    }
+    for _, tt := range tests {
+        t.Run(string(tt.keyType), func(t *testing.T) {
+            if tt.keyType == KeyRSA4096 && testing.Short() {
+                t.Skip("slow key generation")
+            }
+            key, err := GenerateKey(tt.keyType)
+            if err != nil {
+                t.Fatalf("GenerateKey() error = %v", err)
+            }
+            if !tt.check(key) {
+                t.Fatalf("GenerateKey() returned a %T of the wrong size", key)
+            }
+            if got := keyUsage(key); got != tt.keyUsage {
+                t.Errorf("keyUsage() = %v, want %v", got, tt.keyUsage)
+            }
+
+            // The key must survive PEM encoding and sign a usable certificate.
+            block, err := pkcs8Block(key)
+            if err != nil {
+                t.Fatal(err)
+            }
+            parsed, err := parseTestKey(block.Bytes)
+            if err != nil {
+                t.Fatal(err)
+            }
+            template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+            der, err := x509.CreateCertificate(rand.Reader, template, template, parsed.Public(), parsed)
+            if err != nil {
+                t.Fatalf("signing with the key failed: %v", err)
+            }
+            cert, err := x509.ParseCertificate(der)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+                t.Errorf("signature does not verify: %v", err)
+            }
+        })
+    }
+}
+
+func TestGenerateKeyRejectsUnknownType(t *testing.T) {
+    for _, keyType := range []KeyType{"", "rsa1024", "RSA2048", "p521", "ed448"} {
+        if key, err := GenerateKey(keyType); err == nil || key != nil {
+            t.Errorf("GenerateKey(%q) = %T, %v; want an error", keyType, key, err)
+        }
+    }
+}
+
+func rsaBits(bits int) func(crypto.Signer) bool {
+    return func(key crypto.Signer) bool {
+        rsaKey, ok := key.(*rsa.PrivateKey)
+        return ok && rsaKey.N.BitLen() == bits
+    }
+}
+
+func ecdsaCurve(curve elliptic.Curve) func(crypto.Signer) bool {
+    return func(key crypto.Signer) bool {
+        ecKey, ok := key.(*ecdsa.PrivateKey)
+        return ok && ecKey.Curve == curve
+    }
+}
@@ -2,17 +2,20 @@ package tls
 
 import (
     "crypto/rand"
-    "crypto/rsa"
     "crypto/x509"
     "crypto/x509/pkix"
     cryptotls "crypto/tls"
     "encoding/pem"
     "fmt"
-    "math/big"
     "os"
+    "slices"
     "sync"
     "time"
     "context"
+
+    "golang.org/x/crypto/acme"
+
+    "Groxy/watcher"
 )
 
 type Manager struct {
@@ -23,6 +26,14 @@ type Manager struct {
     OnRotation   func(*cryptotls.Certificate)
     OnError      func(error)
     rotateCancel context.CancelFunc
+    store        *CertStore
+    acme         *ACME
+    watcher      *watcher.FileWatcher
+    clientCerts  map[string]*ClientCertManager
+    alerted      map[string]int
+    expiryMutex  sync.Mutex
+    expiryCancel context.CancelFunc
+    ticketCancel context.CancelFunc
 }
 
 func NewManager(config *Config) *Manager {
@@ -32,68 +43,104 @@ func NewManager(config *Config) *Manager {
     }
 }
 
+// GenerateCertificate writes a new certificate for CertConfig, signed by the
+// configured CA when its certificate and key exist and self-signed otherwise.
 func (m *Manager) GenerateCertificate() error {
     cfg := m.config.GetCertificateConfig()
     
-    privateKey, err := rsa.GenerateKey(rand.Reader, cfg.KeySize)
+    privateKey, err := GenerateKey(cfg.KeyType)
     if err != nil {
         return fmt.Errorf("failed to generate private key: %v", err)
     }
 
+    serial, err := randomSerial()
+    if err != nil {
+        return err
+    }
+
     template := x509.Certificate{
-        SerialNumber: big.NewInt(time.Now().Unix()),
+        SerialNumber: serial,
         Subject: pkix.Name{
             CommonName:   cfg.CommonName,
             Organization: cfg.Organization,
             Country:      cfg.Country,
         },
+        DNSNames:              cfg.DNSNames,
+        IPAddresses:           cfg.IPAddresses,
         NotBefore:             time.Now(),
         NotAfter:              time.Now().Add(cfg.Validity),
-        KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+        KeyUsage:              keyUsage(privateKey),
         ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
         BasicConstraintsValid: true,
         IsCA:                  cfg.IsCA,
     }
 
-    certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+    parent := &template
+    signer := privateKey
+    if ca, err := m.loadCA(); err != nil {
+        return err
+    } else if ca != nil {
+        parent = ca.Cert
+        signer = ca.Key
+        if template.NotAfter.After(ca.Cert.NotAfter) {
+            template.NotAfter = ca.Cert.NotAfter
+        }
+    }
+
+    certBytes, err := x509.CreateCertificate(rand.Reader, &template, parent, privateKey.Public(), signer)
     if err != nil {
         return fmt.Errorf("failed to create certificate: %v", err)
     }
 
-    certOut, err := os.Create(m.config.CertFile)
+    keyBlock, err := pkcs8Block(privateKey)
     if err != nil {
-        return fmt.Errorf("failed to open cert.pem for writing: %v", err)
+        return err
     }
-    defer certOut.Close()
+    return writePair(m.config.CertFile, m.config.KeyFile, certBytes, keyBlock)
+}
 
-    if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes}); err != nil {
-        return fmt.Errorf("failed to encode certificate: %v", err)
+// loadCA returns the configured CA, or nil if its files don't exist.
+func (m *Manager) loadCA() (*CA, error) {
+    if m.config.CAFile == "" || m.config.CAKeyFile == "" {
+        return nil, nil
     }
-
-    keyOut, err := os.Create(m.config.KeyFile)
-    if err != nil {
-        return fmt.Errorf("failed to open key.pem for writing: %v", err)
+    for _, path := range []string{m.config.CAFile, m.config.CAKeyFile} {
+        if _, err := os.Stat(path); os.IsNotExist(err) {
+            return nil, nil
+        }
     }
-    defer keyOut.Close()
+    return LoadCA(m.config.CAFile, m.config.CAKeyFile)
+}
 
-    if err := pem.Encode(keyOut, &pem.Block{
-        Type:  "RSA PRIVATE KEY",
-        Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-    }); err != nil {
-        return fmt.Errorf("failed to encode private key: %v", err)
-    }
+// SetCertStore makes GetCertificate select certificates by SNI from store,
+// falling back to the managed certificate when the store has no match.
+func (m *Manager) SetCertStore(store *CertStore) {
+    m.store = store
+}
 
-    return nil
+// SetACME serves the ACME domains with certificates from the ACME CA and
+// answers TLS-ALPN-01 challenges.
+func (m *Manager) SetACME(acme *ACME) {
+    m.acme = acme
 }
 
-func (m *Manager) GetCertificate(*cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error) {
+func (m *Manager) GetCertificate(hello *cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error) {
+    if m.acme != nil && hello != nil && (IsChallenge(hello) || m.acme.Handles(hello.ServerName)) {
+        return m.acme.GetCertificate(hello)
+    }
+    if m.store != nil && hello != nil {
+        if cert := m.store.Lookup(hello.ServerName); cert != nil {
+            return cert, nil
+        }
+    }
+
     m.certMutex.RLock()
     defer m.certMutex.RUnlock()
     return m.currentCert, nil
 }
 
 func (m *Manager) LoadServerConfig() (*cryptotls.Config, error) {
-    cert, err := cryptotls.LoadX509KeyPair(m.config.CertFile, m.config.KeyFile)
+    cert, err := LoadKeyPair(m.config.CertFile, m.config.KeyFile, nil)
     if err != nil {
         return nil, err
     }
@@ -102,7 +149,14 @@ func (m *Manager) LoadServerConfig() (*cryptotls.Config, error) {
     m.currentCert = &cert
     m.certMutex.Unlock()
 
-    return m.config.LoadServerConfig(m.GetCertificate)
+    serverConfig, err := m.config.LoadServerConfig(m.GetCertificate)
+    if err != nil {
+        return nil, err
+    }
+    if m.acme != nil {
+        serverConfig.NextProtos = append(serverConfig.NextProtos, acme.ALPNProto)
+    }
+    return serverConfig, nil
 }
 
 func (m *Manager) StartRotation(interval time.Duration) {
@@ -138,13 +192,76 @@ func (m *Manager) StopRotation() {
 }
 
 func (m *Manager) rotateCertificate() error {
+    owned, err := m.ownsCertificate()
+    if err != nil {
+        return err
+    }
+    if !owned {
+        return fmt.Errorf("%s was not generated by Groxy, not replacing it; use -cert-rotation=0 for certificates managed elsewhere", m.config.CertFile)
+    }
     if err := m.GenerateCertificate(); err != nil {
         return fmt.Errorf("failed to generate certificate: %v", err)
     }
+    if err := m.Reload(); err != nil {
+        return fmt.Errorf("failed to load new certificate: %v", err)
+    }
+    // The watcher would otherwise report our own write as an outside change.
+    if m.watcher != nil {
+        m.watcher.Check()
+    }
+    return nil
+}
 
-    cert, err := cryptotls.LoadX509KeyPair(m.config.CertFile, m.config.KeyFile)
+// ownsCertificate reports whether rotation may replace the certificate file:
+// it does not exist yet, or it was issued for CertConfig, either signed by
+// the configured CA or self-signed with the configured subject.
+func (m *Manager) ownsCertificate() (bool, error) {
+    data, err := os.ReadFile(m.config.CertFile)
+    if os.IsNotExist(err) {
+        return true, nil
+    }
     if err != nil {
-        return fmt.Errorf("failed to load new certificate: %v", err)
+        return false, fmt.Errorf("failed to read %s: %v", m.config.CertFile, err)
+    }
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != "CERTIFICATE" {
+        return false, nil
+    }
+    cert, err := x509.ParseCertificate(block.Bytes)
+    if err != nil {
+        return false, nil
+    }
+
+    ca, err := m.loadCA()
+    if err != nil {
+        return false, err
+    }
+    if ca != nil && cert.CheckSignatureFrom(ca.Cert) == nil {
+        return true, nil
+    }
+    cfg := m.config.GetCertificateConfig()
+    // CheckSignatureFrom would reject a leaf as its own parent.
+    selfSigned := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+    return selfSigned &&
+        cert.Subject.CommonName == cfg.CommonName &&
+        slices.Equal(cert.Subject.Organization, cfg.Organization), nil
+}
+
+// Reload loads the certificate and key files and, once they are known to
+// match and the certificate is currently valid, swaps them in for new
+// handshakes and calls OnRotation. On error the current certificate stays in
+// use.
+func (m *Manager) Reload() error {
+    cert, err := LoadKeyPair(m.config.CertFile, m.config.KeyFile, nil)
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    if now.Before(cert.Leaf.NotBefore) {
+        return fmt.Errorf("certificate %s is not valid until %s", m.config.CertFile, cert.Leaf.NotBefore.Format(time.RFC3339))
+    }
+    if now.After(cert.Leaf.NotAfter) {
+        return fmt.Errorf("certificate %s expired on %s", m.config.CertFile, cert.Leaf.NotAfter.Format(time.RFC3339))
     }
 
     m.certMutex.Lock()
@@ -154,6 +271,24 @@ func (m *Manager) rotateCertificate() error {
     if m.OnRotation != nil {
         m.OnRotation(&cert)
     }
-
     return nil
+}
+
+// StartWatching reloads the certificate when its files change on disk, for
+// certificates renewed by an outside tool.
+func (m *Manager) StartWatching(interval time.Duration) {
+    m.StopWatching()
+    m.watcher = watcher.NewFileWatcher(interval, m.config.CertFile, m.config.KeyFile)
+    m.watcher.OnChange = func() {
+        if err := m.Reload(); err != nil && m.OnError != nil {
+            m.OnError(fmt.Errorf("failed to reload certificate: %v", err))
+        }
+    }
+    m.watcher.Start()
+}
+
+func (m *Manager) StopWatching() {
+    if m.watcher != nil {
+        m.watcher.Stop()
+    }
 }
\ No newline at end of file
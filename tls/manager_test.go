@@ -0,0 +1,140 @@
+package tls
+
+import (
+    "bytes"
+    "encoding/pem"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func newTestManager(t *testing.T) *Manager {
+    t.Helper()
+    dir := t.TempDir()
+    config := NewConfig(filepath.Join(dir, "server-cert.pem"), filepath.Join(dir, "server-key.pem"))
+    config.CAFile = filepath.Join(dir, "ca-cert.pem")
+    config.CAKeyFile = filepath.Join(dir, "ca-key.pem")
+    config.CertConfig.KeyType = KeyECDSAP256
+    return NewManager(config)
+}
+
+func writeExternalPair(t *testing.T, m *Manager, name string) {
+    t.Helper()
+    cert, keyBlock := testCertificate(t, name, time.Now().Add(24*time.Hour))
+    if err := writePair(m.config.CertFile, m.config.KeyFile, cert.Certificate[0], keyBlock); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestWritePair(t *testing.T) {
+    dir := t.TempDir()
+    certFile := filepath.Join(dir, "cert.pem")
+    keyFile := filepath.Join(dir, "key.pem")
+    if err := os.WriteFile(keyFile, []byte("old key"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cert, keyBlock := testCertificate(t, "groxy.test", time.Now().Add(time.Hour))
+    if err := writePair(certFile, keyFile, cert.Certificate[0], keyBlock); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        path string
+        mode os.FileMode
+        want []byte
+    }{
+        {certFile, 0644, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})},
+        {keyFile, 0600, pem.EncodeToMemory(keyBlock)},
+    }
+    for _, tt := range tests {
+        info, err := os.Stat(tt.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info.Mode().Perm() != tt.mode {
+            t.Errorf("%s mode = %v, want %v", filepath.Base(tt.path), info.Mode().Perm(), tt.mode)
+        }
+        data, err := os.ReadFile(tt.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(data, tt.want) {
+            t.Errorf("%s has unexpected contents", filepath.Base(tt.path))
+        }
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 2 {
+        t.Errorf("directory holds %d files, want 2 with no temporary files left", len(entries))
+    }
+}
+
+func TestOwnsCertificate(t *testing.T) {
+    tests := []struct {
+        name  string
+        setup func(t *testing.T, m *Manager)
+        want  bool
+    }{
+        {"missing", func(t *testing.T, m *Manager) {}, true},
+        {"generated self-signed", func(t *testing.T, m *Manager) {
+            if err := m.GenerateCertificate(); err != nil {
+                t.Fatal(err)
+            }
+        }, true},
+        {"generated by the configured CA", func(t *testing.T, m *Manager) {
+            if err := GenerateCA(m.config.CAFile, m.config.CAKeyFile, CAConfig{CommonName: "Test CA", Validity: time.Hour, KeyType: KeyECDSAP256}); err != nil {
+                t.Fatal(err)
+            }
+            if err := m.GenerateCertificate(); err != nil {
+                t.Fatal(err)
+            }
+        }, true},
+        {"external self-signed", func(t *testing.T, m *Manager) {
+            writeExternalPair(t, m, "external.example.com")
+        }, false},
+        {"external with a CA configured", func(t *testing.T, m *Manager) {
+            if err := GenerateCA(m.config.CAFile, m.config.CAKeyFile, CAConfig{CommonName: "Test CA", Validity: time.Hour, KeyType: KeyECDSAP256}); err != nil {
+                t.Fatal(err)
+            }
+            writeExternalPair(t, m, "external.example.com")
+        }, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m := newTestManager(t)
+            tt.setup(t, m)
+            got, err := m.ownsCertificate()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != tt.want {
+                t.Errorf("ownsCertificate() = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRotationKeepsExternalCertificate(t *testing.T) {
+    m := newTestManager(t)
+    writeExternalPair(t, m, "external.example.com")
+    before, err := os.ReadFile(m.config.CertFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := m.rotateCertificate(); err == nil {
+        t.Error("rotateCertificate() replaced an external certificate")
+    }
+    after, err := os.ReadFile(m.config.CertFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(before, after) {
+        t.Error("external certificate was modified")
+    }
+}
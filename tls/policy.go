@@ -0,0 +1,291 @@
+package tls
+
+import (
+    "context"
+    "crypto/rand"
+    cryptotls "crypto/tls"
+    "fmt"
+    "slices"
+    "strings"
+    "time"
+
+    "Groxy/logger"
+)
+
+// Policy is a set of TLS parameters applied to the HTTPS listener or to
+// upstream connections. TLS 1.3 cipher suites are fixed by crypto/tls, so
+// CipherSuites only affects TLS 1.2 and below.
+type Policy struct {
+    Name                   string
+    MinVersion             uint16
+    MaxVersion             uint16
+    CipherSuites           []uint16
+    CurvePreferences       []cryptotls.CurveID
+    NextProtos             []string
+    SessionTicketsDisabled bool
+}
+
+// PolicyOverrides replaces individual settings of a profile. Empty fields
+// keep the profile's value.
+type PolicyOverrides struct {
+    MinVersion            string
+    MaxVersion            string
+    CipherSuites          []string
+    Curves                []string
+    ALPN                  []string
+    DisableSessionTickets bool
+}
+
+var intermediateCiphers = []uint16{
+    cryptotls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+    cryptotls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+    cryptotls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+    cryptotls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+    cryptotls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+    cryptotls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// Policies are the built-in profiles, modelled on Mozilla's server side TLS
+// recommendations.
+var Policies = map[string]Policy{
+    // modern accepts only TLS 1.3.
+    "modern": {
+        Name:             "modern",
+        MinVersion:       cryptotls.VersionTLS13,
+        MaxVersion:       cryptotls.VersionTLS13,
+        CurvePreferences: []cryptotls.CurveID{cryptotls.X25519, cryptotls.CurveP256, cryptotls.CurveP384},
+        NextProtos:       []string{"h2", "http/1.1"},
+    },
+    // intermediate accepts TLS 1.2 with forward-secret AEAD suites and TLS
+    // 1.3.
+    "intermediate": {
+        Name:             "intermediate",
+        MinVersion:       cryptotls.VersionTLS12,
+        MaxVersion:       cryptotls.VersionTLS13,
+        CipherSuites:     intermediateCiphers,
+        CurvePreferences: []cryptotls.CurveID{cryptotls.X25519, cryptotls.CurveP256, cryptotls.CurveP384},
+        NextProtos:       []string{"h2", "http/1.1"},
+    },
+    // legacy also accepts TLS 1.0 and 1.1, CBC suites and RSA key exchange,
+    // for old clients and upstreams only.
+    "legacy": {
+        Name:       "legacy",
+        MinVersion: cryptotls.VersionTLS10,
+        MaxVersion: cryptotls.VersionTLS13,
+        CipherSuites: append(slices.Clone(intermediateCiphers),
+            cryptotls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+            cryptotls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+            cryptotls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+            cryptotls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+            cryptotls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+            cryptotls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+            cryptotls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+            cryptotls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+            cryptotls.TLS_RSA_WITH_AES_128_CBC_SHA,
+            cryptotls.TLS_RSA_WITH_AES_256_CBC_SHA,
+            cryptotls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+        ),
+        CurvePreferences: []cryptotls.CurveID{cryptotls.X25519, cryptotls.CurveP256, cryptotls.CurveP384, cryptotls.CurveP521},
+        NextProtos:       []string{"h2", "http/1.1"},
+    },
+}
+
+// NewPolicy returns the named profile with overrides applied.
+func NewPolicy(profile string, overrides PolicyOverrides) (*Policy, error) {
+    base, ok := Policies[strings.ToLower(profile)]
+    if !ok {
+        return nil, fmt.Errorf("unknown TLS policy %q (want modern, intermediate or legacy)", profile)
+    }
+    policy := base.clone()
+
+    var err error
+    if overrides.MinVersion != "" {
+        if policy.MinVersion, err = parseVersion(overrides.MinVersion); err != nil {
+            return nil, err
+        }
+    }
+    if overrides.MaxVersion != "" {
+        if policy.MaxVersion, err = parseVersion(overrides.MaxVersion); err != nil {
+            return nil, err
+        }
+    }
+    if policy.MinVersion > policy.MaxVersion {
+        return nil, fmt.Errorf("TLS policy minimum version %s is above maximum %s",
+            cryptotls.VersionName(policy.MinVersion), cryptotls.VersionName(policy.MaxVersion))
+    }
+    if len(overrides.CipherSuites) > 0 {
+        policy.CipherSuites = nil
+        for _, name := range overrides.CipherSuites {
+            id, err := parseCipherSuite(name)
+            if err != nil {
+                return nil, err
+            }
+            policy.CipherSuites = append(policy.CipherSuites, id)
+        }
+    }
+    if len(overrides.Curves) > 0 {
+        policy.CurvePreferences = nil
+        for _, name := range overrides.Curves {
+            id, err := parseCurve(name)
+            if err != nil {
+                return nil, err
+            }
+            policy.CurvePreferences = append(policy.CurvePreferences, id)
+        }
+    }
+    if len(overrides.ALPN) > 0 {
+        policy.NextProtos = slices.Clone(overrides.ALPN)
+    }
+    if overrides.DisableSessionTickets {
+        policy.SessionTicketsDisabled = true
+    }
+    return policy, nil
+}
+
+// clone returns a copy of p that shares no slices with it, so the built-in
+// profiles cannot be changed through a policy derived from them.
+func (p Policy) clone() *Policy {
+    p.CipherSuites = slices.Clone(p.CipherSuites)
+    p.CurvePreferences = slices.Clone(p.CurvePreferences)
+    p.NextProtos = slices.Clone(p.NextProtos)
+    return &p
+}
+
+func parseVersion(value string) (uint16, error) {
+    switch strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(value, " ", "")), "tls") {
+    case "1.0", "10":
+        return cryptotls.VersionTLS10, nil
+    case "1.1", "11":
+        return cryptotls.VersionTLS11, nil
+    case "1.2", "12":
+        return cryptotls.VersionTLS12, nil
+    case "1.3", "13":
+        return cryptotls.VersionTLS13, nil
+    }
+    return 0, fmt.Errorf("unknown TLS version %q", value)
+}
+
+func parseCipherSuite(name string) (uint16, error) {
+    name = strings.TrimSpace(name)
+    for _, suite := range append(cryptotls.CipherSuites(), cryptotls.InsecureCipherSuites()...) {
+        if strings.EqualFold(suite.Name, name) {
+            return suite.ID, nil
+        }
+    }
+    return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+func parseCurve(name string) (cryptotls.CurveID, error) {
+    switch strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(name), "-", "")) {
+    case "X25519":
+        return cryptotls.X25519, nil
+    case "P256", "SECP256R1":
+        return cryptotls.CurveP256, nil
+    case "P384", "SECP384R1":
+        return cryptotls.CurveP384, nil
+    case "P521", "SECP521R1":
+        return cryptotls.CurveP521, nil
+    }
+    return 0, fmt.Errorf("unknown curve %q", name)
+}
+
+// applyServer sets the policy on the listener config and logs every
+// negotiated connection.
+func (p *Policy) applyServer(config *cryptotls.Config) {
+    p.applyCommon(config)
+    config.NextProtos = slices.Clone(p.NextProtos)
+    config.SessionTicketsDisabled = p.SessionTicketsDisabled
+    config.VerifyConnection = func(state cryptotls.ConnectionState) error {
+        logHandshake("client", state)
+        return nil
+    }
+}
+
+// applyClient sets the policy on an upstream config. ALPN is left to the
+// HTTP transport, which knows whether it can speak HTTP/2.
+func (p *Policy) applyClient(config *cryptotls.Config) {
+    p.applyCommon(config)
+    config.VerifyConnection = func(state cryptotls.ConnectionState) error {
+        logHandshake("upstream", state)
+        return nil
+    }
+}
+
+func (p *Policy) applyCommon(config *cryptotls.Config) {
+    config.MinVersion = p.MinVersion
+    config.MaxVersion = p.MaxVersion
+    config.CipherSuites = slices.Clone(p.CipherSuites)
+    config.CurvePreferences = slices.Clone(p.CurvePreferences)
+}
+
+func logHandshake(peer string, state cryptotls.ConnectionState) {
+    alpn := state.NegotiatedProtocol
+    if alpn == "" {
+        alpn = "none"
+    }
+    logger.Debug("TLS with %s %q: %s, %s, ALPN %s, resumed %t",
+        peer, state.ServerName, cryptotls.VersionName(state.Version), cryptotls.CipherSuiteName(state.CipherSuite), alpn, state.DidResume)
+}
+
+// StartTicketRotation replaces the listener's session ticket key every
+// interval. The previous keys are kept for one more interval each so that
+// recently issued tickets still resume.
+func (m *Manager) StartTicketRotation(config *cryptotls.Config, interval time.Duration) error {
+    m.StopTicketRotation()
+    if config.SessionTicketsDisabled || interval <= 0 {
+        return nil
+    }
+
+    var keys [][32]byte
+    rotate := func() error {
+        var key [32]byte
+        if _, err := rand.Read(key[:]); err != nil {
+            return fmt.Errorf("failed to generate session ticket key: %v", err)
+        }
+        keys = append([][32]byte{key}, keys...)
+        if len(keys) > 3 {
+            keys = keys[:3]
+        }
+        config.SetSessionTicketKeys(keys)
+        return nil
+    }
+    if err := rotate(); err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    m.ticketCancel = cancel
+
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ticker.C:
+                if err := rotate(); err != nil {
+                    if m.OnError != nil {
+                        m.OnError(err)
+                    }
+                    continue
+                }
+                logger.Debug("Rotated TLS session ticket key")
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return nil
+}
+
+// TicketKeyRotation returns the configured session ticket key lifetime.
+func (m *Manager) TicketKeyRotation() time.Duration {
+    return m.config.TicketKeyRotation
+}
+
+func (m *Manager) StopTicketRotation() {
+    if m.ticketCancel != nil {
+        m.ticketCancel()
+        m.ticketCancel = nil
+    }
+}
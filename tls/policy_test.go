@@ -0,0 +1,127 @@
+package tls
+
+import (
+    cryptotls "crypto/tls"
+    "reflect"
+    "slices"
+    "testing"
+)
+
+func TestNewPolicyVersions(t *testing.T) {
+    tests := []struct {
+        name    string
+        profile string
+        min     string
+        max     string
+        wantMin uint16
+        wantMax uint16
+        wantErr bool
+    }{
+        {"profile defaults", "intermediate", "", "", cryptotls.VersionTLS12, cryptotls.VersionTLS13, false},
+        {"profile name is case-insensitive", "Modern", "", "", cryptotls.VersionTLS13, cryptotls.VersionTLS13, false},
+        {"raised minimum", "intermediate", "TLS1.3", "", cryptotls.VersionTLS13, cryptotls.VersionTLS13, false},
+        {"lowered minimum", "modern", "1.2", "", cryptotls.VersionTLS12, cryptotls.VersionTLS13, false},
+        {"both bounds", "legacy", "tls 1.0", "12", cryptotls.VersionTLS10, cryptotls.VersionTLS12, false},
+        {"equal bounds", "legacy", "1.1", "tls11", cryptotls.VersionTLS11, cryptotls.VersionTLS11, false},
+        {"minimum above maximum", "intermediate", "1.3", "1.2", 0, 0, true},
+        {"maximum below profile minimum", "modern", "", "1.2", 0, 0, true},
+        {"unknown minimum", "intermediate", "1.4", "", 0, 0, true},
+        {"unknown maximum", "intermediate", "", "ssl3", 0, 0, true},
+        {"unknown profile", "strict", "", "", 0, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            policy, err := NewPolicy(tt.profile, PolicyOverrides{MinVersion: tt.min, MaxVersion: tt.max})
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("NewPolicy() error = %v, wantErr %t", err, tt.wantErr)
+            }
+            if err != nil {
+                return
+            }
+            if policy.MinVersion != tt.wantMin || policy.MaxVersion != tt.wantMax {
+                t.Errorf("versions = %s-%s, want %s-%s",
+                    cryptotls.VersionName(policy.MinVersion), cryptotls.VersionName(policy.MaxVersion),
+                    cryptotls.VersionName(tt.wantMin), cryptotls.VersionName(tt.wantMax))
+            }
+        })
+    }
+}
+
+func TestNewPolicyCipherSuitesAndCurves(t *testing.T) {
+    tests := []struct {
+        name       string
+        overrides  PolicyOverrides
+        wantSuites []uint16
+        wantCurves []cryptotls.CurveID
+        wantErr    bool
+    }{
+        {"profile defaults", PolicyOverrides{}, intermediateCiphers, []cryptotls.CurveID{cryptotls.X25519, cryptotls.CurveP256, cryptotls.CurveP384}, false},
+        {"suites by name", PolicyOverrides{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", " tls_ecdhe_ecdsa_with_aes_128_gcm_sha256 "}},
+            []uint16{cryptotls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, cryptotls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, nil, false},
+        {"insecure suite by name", PolicyOverrides{CipherSuites: []string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"}},
+            []uint16{cryptotls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, nil, false},
+        {"curve aliases", PolicyOverrides{Curves: []string{"P-384", "secp256r1", "x25519", "P521"}},
+            nil, []cryptotls.CurveID{cryptotls.CurveP384, cryptotls.CurveP256, cryptotls.X25519, cryptotls.CurveP521}, false},
+        {"unknown suite", PolicyOverrides{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_NOPE"}}, nil, nil, true},
+        {"unknown curve", PolicyOverrides{Curves: []string{"X25519", "brainpoolP256r1"}}, nil, nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            policy, err := NewPolicy("intermediate", tt.overrides)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("NewPolicy() error = %v, wantErr %t", err, tt.wantErr)
+            }
+            if err != nil {
+                return
+            }
+            if tt.wantSuites != nil && !slices.Equal(policy.CipherSuites, tt.wantSuites) {
+                t.Errorf("CipherSuites = %v, want %v", policy.CipherSuites, tt.wantSuites)
+            }
+            if tt.wantCurves != nil && !slices.Equal(policy.CurvePreferences, tt.wantCurves) {
+                t.Errorf("CurvePreferences = %v, want %v", policy.CurvePreferences, tt.wantCurves)
+            }
+        })
+    }
+}
+
+func TestPoliciesAreNotShared(t *testing.T) {
+    snapshot := make(map[string]*Policy)
+    for name, policy := range Policies {
+        snapshot[name] = policy.clone()
+    }
+
+    overrides := PolicyOverrides{
+        MinVersion:            "1.2",
+        CipherSuites:          []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+        Curves:                []string{"P-256"},
+        ALPN:                  []string{"http/1.1"},
+        DisableSessionTickets: true,
+    }
+    var derived []*Policy
+    for name := range Policies {
+        for _, o := range []PolicyOverrides{{}, overrides} {
+            policy, err := NewPolicy(name, o)
+            if err != nil {
+                t.Fatal(err)
+            }
+            derived = append(derived, policy)
+        }
+    }
+    derived = append(derived, NewConfig("", "").serverPolicy(), NewConfig("", "").upstreamPolicy())
+
+    for _, policy := range derived {
+        policy.MinVersion = cryptotls.VersionSSL30
+        policy.SessionTicketsDisabled = true
+        if len(policy.CipherSuites) > 0 {
+            policy.CipherSuites[0] = cryptotls.TLS_RSA_WITH_RC4_128_SHA
+        }
+        policy.CurvePreferences[0] = cryptotls.CurveID(0)
+        policy.NextProtos[0] = "spdy/3"
+    }
+
+    for name, want := range snapshot {
+        if got := Policies[name]; !reflect.DeepEqual(&got, want) {
+            t.Errorf("profile %s was modified through a derived policy: %+v", name, got)
+        }
+    }
+}
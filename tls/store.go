@@ -0,0 +1,207 @@
+package tls
+
+import (
+    cryptotls "crypto/tls"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "Groxy/logger"
+    "Groxy/watcher"
+)
+
+// CertStore selects a server certificate by SNI. Names are matched exactly
+// first, then against wildcard certificates covering one label
+// (*.example.com matches a.example.com but not a.b.example.com), and finally
+// the default certificate is used.
+type CertStore struct {
+    exact       map[string]*cryptotls.Certificate
+    wildcard    map[string]*cryptotls.Certificate
+    defaultCert *cryptotls.Certificate
+    mu          sync.RWMutex
+    dir         string
+    watcher     *watcher.FileWatcher
+}
+
+func NewCertStore() *CertStore {
+    return &CertStore{
+        exact:    make(map[string]*cryptotls.Certificate),
+        wildcard: make(map[string]*cryptotls.Certificate),
+    }
+}
+
+// Add indexes cert under its DNS SANs, or its common name if it has none.
+func (s *CertStore) Add(cert *cryptotls.Certificate) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    index(s.exact, s.wildcard, cert)
+}
+
+func (s *CertStore) SetDefault(cert *cryptotls.Certificate) {
+    s.mu.Lock()
+    s.defaultCert = cert
+    s.mu.Unlock()
+}
+
+func index(exact, wildcard map[string]*cryptotls.Certificate, cert *cryptotls.Certificate) {
+    names := cert.Leaf.DNSNames
+    if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+        names = []string{cert.Leaf.Subject.CommonName}
+    }
+    for _, name := range names {
+        name = strings.ToLower(strings.TrimSuffix(name, "."))
+        if suffix, ok := strings.CutPrefix(name, "*."); ok {
+            wildcard[suffix] = cert
+        } else {
+            exact[name] = cert
+        }
+    }
+}
+
+// Lookup returns the certificate for serverName, or nil if neither a name
+// nor a default matches.
+func (s *CertStore) Lookup(serverName string) *cryptotls.Certificate {
+    name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if name != "" {
+        if cert, ok := s.exact[name]; ok {
+            return cert
+        }
+        if _, parent, ok := strings.Cut(name, "."); ok {
+            if cert, ok := s.wildcard[parent]; ok {
+                return cert
+            }
+        }
+    }
+    return s.defaultCert
+}
+
+func (s *CertStore) GetCertificate(hello *cryptotls.ClientHelloInfo) (*cryptotls.Certificate, error) {
+    if cert := s.Lookup(hello.ServerName); cert != nil {
+        return cert, nil
+    }
+    return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// Certificates returns each distinct certificate in the store.
+func (s *CertStore) Certificates() []*cryptotls.Certificate {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    seen := make(map[*cryptotls.Certificate]bool)
+    var certs []*cryptotls.Certificate
+    add := func(cert *cryptotls.Certificate) {
+        if cert != nil && !seen[cert] {
+            seen[cert] = true
+            certs = append(certs, cert)
+        }
+    }
+    add(s.defaultCert)
+    for _, cert := range s.exact {
+        add(cert)
+    }
+    for _, cert := range s.wildcard {
+        add(cert)
+    }
+    return certs
+}
+
+// LoadDirectory replaces the store's certificates with the PEM pairs in dir.
+// A certificate "name.pem" or "name.crt" is paired with "name.key", and
+// "name-cert.pem" with "name-key.pem". The pair named "default" becomes the
+// default certificate; without one the store has no default, so removing
+// default.pem stops it being served. Pairs that fail to load are skipped and logged; the
+// swap happens only once the whole directory has been read.
+func (s *CertStore) LoadDirectory(dir string) error {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return fmt.Errorf("failed to read certificate directory: %v", err)
+    }
+
+    exact := make(map[string]*cryptotls.Certificate)
+    wildcard := make(map[string]*cryptotls.Certificate)
+    var defaultCert *cryptotls.Certificate
+    loaded := 0
+
+    for _, entry := range entries {
+        name, keyFile, ok := certPair(entry.Name())
+        if entry.IsDir() || !ok {
+            continue
+        }
+        certPath := filepath.Join(dir, entry.Name())
+        keyPath := filepath.Join(dir, keyFile)
+        if _, err := os.Stat(keyPath); err != nil {
+            logger.Warning("Skipping certificate %s: no key file %s", certPath, keyFile)
+            continue
+        }
+
+        cert, err := LoadKeyPair(certPath, keyPath, nil)
+        if err != nil {
+            logger.Error("Skipping certificate %s: %v", certPath, err)
+            continue
+        }
+        if name == "default" {
+            defaultCert = &cert
+        }
+        index(exact, wildcard, &cert)
+        loaded++
+    }
+
+    s.mu.Lock()
+    s.exact = exact
+    s.wildcard = wildcard
+    s.defaultCert = defaultCert
+    s.dir = dir
+    s.mu.Unlock()
+
+    logger.Info("Loaded %d certificates from %s", loaded, dir)
+    return nil
+}
+
+// certPair returns the pair name and key file name for a certificate file
+// name.
+func certPair(file string) (string, string, bool) {
+    if name, ok := strings.CutSuffix(file, "-cert.pem"); ok {
+        return name, name + "-key.pem", true
+    }
+    if strings.HasSuffix(file, "-key.pem") {
+        return "", "", false
+    }
+    for _, ext := range []string{".pem", ".crt"} {
+        if name, ok := strings.CutSuffix(file, ext); ok {
+            return name, name + ".key", true
+        }
+    }
+    return "", "", false
+}
+
+// StartWatching reloads the directory when a file in it is added, removed
+// or changed.
+func (s *CertStore) StartWatching(interval time.Duration) {
+    s.mu.RLock()
+    dir := s.dir
+    s.mu.RUnlock()
+    if dir == "" {
+        return
+    }
+
+    s.watcher = watcher.NewFileWatcher(interval, dir)
+    s.watcher.OnChange = func() {
+        if err := s.LoadDirectory(dir); err != nil {
+            logger.Error("Failed to reload certificates: %v", err)
+        }
+    }
+    s.watcher.Start()
+}
+
+func (s *CertStore) StopWatching() {
+    if s.watcher != nil {
+        s.watcher.Stop()
+    }
+}
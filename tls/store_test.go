@@ -0,0 +1,80 @@
+package tls
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func writeStorePair(t *testing.T, dir, name, host string) {
+    t.Helper()
+    cert, keyBlock := testCertificate(t, host, time.Now().Add(24*time.Hour))
+    if err := writePair(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"), cert.Certificate[0], keyBlock); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCertStoreLookup(t *testing.T) {
+    dir := t.TempDir()
+    writeStorePair(t, dir, "default", "default.example.com")
+    writeStorePair(t, dir, "api", "api.example.com")
+    writeStorePair(t, dir, "wildcard", "*.apps.example.com")
+
+    store := NewCertStore()
+    if err := store.LoadDirectory(dir); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        serverName string
+        want       string
+    }{
+        {"api.example.com", "api.example.com"},
+        {"API.example.com.", "api.example.com"},
+        {"one.apps.example.com", "*.apps.example.com"},
+        {"two.levels.apps.example.com", "default.example.com"},
+        {"unknown.example.com", "default.example.com"},
+        {"", "default.example.com"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.serverName, func(t *testing.T) {
+            cert := store.Lookup(tt.serverName)
+            if cert == nil {
+                t.Fatalf("Lookup(%q) = nil, want %s", tt.serverName, tt.want)
+            }
+            if got := cert.Leaf.DNSNames[0]; got != tt.want {
+                t.Errorf("Lookup(%q) = %s, want %s", tt.serverName, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCertStoreReloadClearsDefault(t *testing.T) {
+    dir := t.TempDir()
+    writeStorePair(t, dir, "default", "default.example.com")
+    writeStorePair(t, dir, "api", "api.example.com")
+
+    store := NewCertStore()
+    if err := store.LoadDirectory(dir); err != nil {
+        t.Fatal(err)
+    }
+    if store.Lookup("unknown.example.com") == nil {
+        t.Fatal("default certificate not loaded")
+    }
+
+    for _, file := range []string{"default.pem", "default.key"} {
+        if err := os.Remove(filepath.Join(dir, file)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := store.LoadDirectory(dir); err != nil {
+        t.Fatal(err)
+    }
+    if cert := store.Lookup("unknown.example.com"); cert != nil {
+        t.Errorf("removed default certificate %s is still served", cert.Leaf.DNSNames[0])
+    }
+    if store.Lookup("api.example.com") == nil {
+        t.Error("api.example.com no longer served")
+    }
+}
@@ -0,0 +1,138 @@
+package tls
+
+import (
+    cryptotls "crypto/tls"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// UpstreamOptions tunes how an upstream's certificate is verified.
+type UpstreamOptions struct {
+    // RootCAFile replaces the system roots with a PEM bundle.
+    RootCAFile string
+    // SPKIPins are SHA-256 fingerprints of subject public key infos (hex or
+    // base64). When set, at least one certificate of the verified chain must
+    // match.
+    SPKIPins []string
+    // Insecure skips chain and hostname verification. Pins, if any, are
+    // still enforced, but only against the leaf since there is no verified
+    // chain.
+    Insecure bool
+    // ClientCert is presented when the upstream asks for a certificate.
+    ClientCert *ClientCertManager
+}
+
+// PinError reports a chain in which no certificate matched a pin.
+type PinError struct {
+    Cert *x509.Certificate
+}
+
+func (e *PinError) Error() string {
+    return fmt.Sprintf("no certificate in chain matches a pinned SPKI (leaf %s)", e.Cert.Subject.String())
+}
+
+// LoadUpstreamConfig returns a client config for one upstream.
+func (c *Config) LoadUpstreamConfig(opts UpstreamOptions) (*cryptotls.Config, error) {
+    config := c.LoadClientConfig()
+
+    if opts.RootCAFile != "" {
+        pool, err := loadCertPool(opts.RootCAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load CA bundle: %v", err)
+        }
+        config.RootCAs = pool
+    }
+
+    pins := make(map[string]bool, len(opts.SPKIPins))
+    for _, pin := range opts.SPKIPins {
+        normalized, err := NormalizePin(pin)
+        if err != nil {
+            return nil, err
+        }
+        pins[normalized] = true
+    }
+
+    config.InsecureSkipVerify = opts.Insecure
+    if opts.ClientCert != nil {
+        config.GetClientCertificate = opts.ClientCert.GetClientCertificate
+    }
+    if len(pins) > 0 {
+        next := config.VerifyConnection
+        config.VerifyConnection = func(state cryptotls.ConnectionState) error {
+            if !pinned(state, pins) {
+                return &PinError{Cert: state.PeerCertificates[0]}
+            }
+            if next != nil {
+                return next(state)
+            }
+            return nil
+        }
+    }
+    return config, nil
+}
+
+// pinned reports whether a pin matches a certificate of a verified chain or,
+// when verification is skipped, the leaf. The rest of an unverified chain is
+// whatever the server chose to send: it could append a public pinned CA
+// certificate without holding its key, whereas the handshake proves the
+// server holds the leaf's.
+func pinned(state cryptotls.ConnectionState, pins map[string]bool) bool {
+    if len(state.VerifiedChains) == 0 {
+        return len(state.PeerCertificates) > 0 && pins[SPKIFingerprint(state.PeerCertificates[0])]
+    }
+    for _, chain := range state.VerifiedChains {
+        for _, cert := range chain {
+            if pins[SPKIFingerprint(cert)] {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// SPKIFingerprint returns the hex SHA-256 of the certificate's public key
+// info.
+func SPKIFingerprint(cert *x509.Certificate) string {
+    sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+    return hex.EncodeToString(sum[:])
+}
+
+// NormalizePin converts an SPKI SHA-256 fingerprint given as hex (colons
+// allowed) or base64, optionally prefixed with "sha256/", to lowercase hex.
+func NormalizePin(pin string) (string, error) {
+    value := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+    if decoded, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil && len(decoded) == sha256.Size {
+        return hex.EncodeToString(decoded), nil
+    }
+    if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) == sha256.Size {
+        return hex.EncodeToString(decoded), nil
+    }
+    return "", fmt.Errorf("invalid SPKI pin %q", pin)
+}
+
+// FailedCertificate extracts the certificate an upstream handshake error is
+// about, or nil if err is not a certificate verification failure.
+func FailedCertificate(err error) *x509.Certificate {
+    var pinErr *PinError
+    if errors.As(err, &pinErr) {
+        return pinErr.Cert
+    }
+    var verifyErr *cryptotls.CertificateVerificationError
+    if errors.As(err, &verifyErr) && len(verifyErr.UnverifiedCertificates) > 0 {
+        return verifyErr.UnverifiedCertificates[0]
+    }
+    var hostnameErr x509.HostnameError
+    if errors.As(err, &hostnameErr) {
+        return hostnameErr.Certificate
+    }
+    var authorityErr x509.UnknownAuthorityError
+    if errors.As(err, &authorityErr) {
+        return authorityErr.Cert
+    }
+    return nil
+}
@@ -0,0 +1,164 @@
+package tls
+
+import (
+    "crypto"
+    "crypto/rand"
+    cryptotls "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+type testIssuer struct {
+    cert *x509.Certificate
+    key  crypto.Signer
+}
+
+func newTestIssuer(t *testing.T, name string) *testIssuer {
+    t.Helper()
+    key, err := GenerateKey(KeyECDSAP256)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial, err := randomSerial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          serial,
+        Subject:               pkix.Name{CommonName: name},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testIssuer{cert: cert, key: key}
+}
+
+func (i *testIssuer) issueServer(t *testing.T, host string) cryptotls.Certificate {
+    t.Helper()
+    key, err := GenerateKey(KeyECDSAP256)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial, err := randomSerial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: serial,
+        Subject:      pkix.Name{CommonName: host},
+        DNSNames:     []string{host},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, i.cert, key.Public(), i.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cryptotls.Certificate{Certificate: [][]byte{der, i.cert.Raw}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestUpstreamPins(t *testing.T) {
+    issuer := newTestIssuer(t, "Upstream CA")
+    // A well-known CA whose certificate anyone can append to a chain.
+    public := newTestIssuer(t, "Public CA")
+    serverCert := issuer.issueServer(t, "upstream.test")
+    withPublic := serverCert
+    withPublic.Certificate = append([][]byte{}, serverCert.Certificate...)
+    withPublic.Certificate = append(withPublic.Certificate, public.cert.Raw)
+
+    caFile := filepath.Join(t.TempDir(), "upstream-ca.pem")
+    if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.cert.Raw}), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name     string
+        served   cryptotls.Certificate
+        insecure bool
+        pin      *x509.Certificate
+        wantPin  bool
+    }{
+        {"verified, leaf pinned", serverCert, false, serverCert.Leaf, true},
+        {"verified, issuer pinned", serverCert, false, issuer.cert, true},
+        {"verified, other key pinned", serverCert, false, public.cert, false},
+        {"verified, appended CA pinned", withPublic, false, public.cert, false},
+        {"insecure, leaf pinned", serverCert, true, serverCert.Leaf, true},
+        {"insecure, other key pinned", serverCert, true, public.cert, false},
+        {"insecure, unverified issuer pinned", serverCert, true, issuer.cert, false},
+        {"insecure, appended CA pinned", withPublic, true, public.cert, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+            server.TLS = &cryptotls.Config{Certificates: []cryptotls.Certificate{tt.served}}
+            server.StartTLS()
+            defer server.Close()
+
+            config, err := NewConfig("", "").LoadUpstreamConfig(UpstreamOptions{
+                RootCAFile: caFile,
+                SPKIPins:   []string{SPKIFingerprint(tt.pin)},
+                Insecure:   tt.insecure,
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            config.ServerName = "upstream.test"
+
+            conn, err := cryptotls.Dial("tcp", server.Listener.Addr().String(), config)
+            if err == nil {
+                conn.Close()
+            }
+            var pinErr *PinError
+            if tt.wantPin && err != nil {
+                t.Errorf("handshake failed: %v", err)
+            }
+            if !tt.wantPin && !errors.As(err, &pinErr) {
+                t.Errorf("handshake error = %v, want a PinError", err)
+            }
+        })
+    }
+}
+
+func TestNormalizePin(t *testing.T) {
+    hex := "ec722969cb64200ab6638f68ac538e40abab5b19a6485661042a1061c4612776"
+    tests := []struct {
+        pin     string
+        want    string
+        wantErr bool
+    }{
+        {hex, hex, false},
+        {"EC:72:29:69:CB:64:20:0A:B6:63:8F:68:AC:53:8E:40:AB:AB:5B:19:A6:48:56:61:04:2A:10:61:C4:61:27:76", hex, false},
+        {"sha256/7HIpactkIAq2Y49orFOOQKurWxmmSFZhBCoQYcRhJ3Y=", hex, false},
+        {"ec7229a9", "", true},
+        {"not a pin", "", true},
+    }
+    for _, tt := range tests {
+        got, err := NormalizePin(tt.pin)
+        if (err != nil) != tt.wantErr || got != tt.want {
+            t.Errorf("NormalizePin(%q) = %q, %v; want %q", tt.pin, got, err, tt.want)
+        }
+    }
+}
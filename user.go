@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"Groxy/auth"
+)
+
+// runUserCommand implements `groxy user add|remove <name>`, which maintains
+// the htpasswd file used by -auth-method=htpasswd.
+func runUserCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage: groxy user add [-file path] [-password password] <username>")
+		fmt.Println("       groxy user remove [-file path] <username>")
+		fmt.Println("Without -password, the password is read from the first line of stdin.")
+	}
+
+	if len(args) < 1 {
+		usage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	file := fs.String("file", ".htpasswd", "htpasswd file to update")
+	password := fs.String("password", "", "Password for the user (add only)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		usage()
+		return 1
+	}
+	username := fs.Arg(0)
+
+	switch action {
+	case "add":
+		if *password == "" {
+			fmt.Fprint(os.Stderr, "Password: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Printf("Failed to read password: %v\n", err)
+				return 1
+			}
+			*password = strings.TrimRight(line, "\r\n")
+		}
+		if *password == "" {
+			fmt.Println("Error: Password must not be empty")
+			return 1
+		}
+		if err := auth.SetHtpasswdUser(*file, username, *password); err != nil {
+			fmt.Printf("Failed to add user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("User %s saved to %s\n", username, *file)
+
+	case "remove":
+		if err := auth.RemoveHtpasswdUser(*file, username); err != nil {
+			fmt.Printf("Failed to remove user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("User %s removed from %s\n", username, *file)
+
+	default:
+		usage()
+		return 1
+	}
+	return 0
+}
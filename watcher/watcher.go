@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileWatcher polls a set of paths and calls OnChange when any of them is
+// created, removed, resized or modified. A directory path covers the files
+// directly inside it, so adding or removing one counts as a change. Polling
+// keeps it dependency-free and works the same on every platform and inside
+// containers.
+type FileWatcher struct {
+	paths    []string
+	interval time.Duration
+	OnChange func()
+
+	mu     sync.Mutex
+	state  map[string]fileState
+	cancel context.CancelFunc
+}
+
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func NewFileWatcher(interval time.Duration, paths ...string) *FileWatcher {
+	w := &FileWatcher{
+		paths:    paths,
+		interval: interval,
+	}
+	w.state = w.snapshot()
+	return w
+}
+
+func (w *FileWatcher) snapshot() map[string]fileState {
+	state := make(map[string]fileState, len(w.paths))
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			state[path] = fileState{}
+			continue
+		}
+		if !info.IsDir() {
+			state[path] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+			continue
+		}
+
+		state[path] = fileState{exists: true}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && !entry.IsDir() {
+				state[filepath.Join(path, entry.Name())] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+			}
+		}
+	}
+	return state
+}
+
+// Check polls once and reports whether anything changed since the last poll.
+func (w *FileWatcher) Check() bool {
+	current := w.snapshot()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := len(current) != len(w.state)
+	for path, state := range current {
+		if w.state[path] != state {
+			changed = true
+			break
+		}
+	}
+	w.state = current
+	return changed
+}
+
+func (w *FileWatcher) Start() {
+	w.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.mu.Lock()
+	w.cancel = cancel
+	w.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if w.Check() && w.OnChange != nil {
+					w.OnChange()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (w *FileWatcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+}